@@ -7,17 +7,23 @@ import (
 )
 
 func main() {
-	output, url, background, file, rateLimit, mirror, reject, exclude, convertLinks, path, err := utils.CheckFlags()
+	opts, err := utils.CheckFlags()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if mirror {
+	if opts.Mirror {
 		// Handle mirroring
-		if url == "" {
+		if opts.URL == "" {
 			log.Fatal("URL is required for mirroring")
 		}
-		err := utils.MirrorWebsite(url, reject, exclude, convertLinks)
+		if opts.Background {
+			utils.RunInBackground(func() error {
+				return utils.MirrorWebsite(opts.URL, opts.MirrorOptions)
+			})
+			return
+		}
+		err := utils.MirrorWebsite(opts.URL, opts.MirrorOptions)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -25,13 +31,20 @@ func main() {
 	}
 
 	// Handle multi-file download case
-	if file != "" {
-		urls, err := utils.ReadUrlsFromFile(file)
+	if opts.InputFile != "" {
+		urls, err := utils.ReadUrlsFromFile(opts.InputFile)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if opts.Concat {
+			err = utils.DownloadFilesConcatenated(urls, opts.Output, opts.Background, opts.RateLimit, opts.Path, opts.Jobs, opts.Limits)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 		// Pass rate limit and output directory to concurrent download function
-		err = utils.DownloadFilesConcurrently(urls, output, background, rateLimit, path)
+		err = utils.DownloadFilesConcurrently(urls, opts.Output, opts.Background, opts.RateLimit, opts.Path, opts.Jobs, opts.Limits)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -39,19 +52,19 @@ func main() {
 	}
 
 	// Handle single file download case
-	if url == "" {
+	if opts.URL == "" {
 		log.Fatal("URL is required for single file download")
 	}
 
-	filename := output
+	filename := opts.Output
 	if filename == "" {
-		filename = utils.GetFileName(url)
+		filename = utils.GetFileName(opts.URL)
 	}
 
 	// Combine path and filename if path is specified
-	if path != "" {
-		filename = filepath.Join(path, filename)
+	if opts.Path != "" {
+		filename = filepath.Join(opts.Path, filename)
 	}
 
-	utils.DownloadWithLogging(url, filename, background, rateLimit)
+	utils.DownloadWithLogging(opts.URL, filename, opts.Background, opts.RateLimit, opts.MaxFileSize)
 }
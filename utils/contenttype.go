@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// contentKind is what the mirror does with a downloaded file: HTML is parsed for
+// links, CSS is scanned for url() and @import, anything else is just saved
+type contentKind int
+
+const (
+	otherContent contentKind = iota
+	htmlContent
+	cssContent
+)
+
+// sniffLen is how much of a body http.DetectContentType looks at
+const sniffLen = 512
+
+// detectContent decides the kind of a file from its Content-Type header, falling back
+// to sniffing the first bytes of the body when the header is missing or says nothing
+// more than application/octet-stream
+func detectContent(contentType string, head []byte) contentKind {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+
+	switch strings.ToLower(mediaType) {
+	case "text/html", "application/xhtml+xml":
+		return htmlContent
+	case "text/css":
+		return cssContent
+	}
+	return otherContent
+}
+
+// readHead returns up to sniffLen bytes from the start of a file
+func readHead(path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, head)
+	return head[:n]
+}
+
+// adjustExtension appends the extension matching kind to name unless it already has
+// it, as --adjust-extension asks: page.php?id=1 becomes page.php?id=1.html
+func adjustExtension(name string, kind contentKind) string {
+	lower := strings.ToLower(name)
+	switch kind {
+	case htmlContent:
+		if !strings.HasSuffix(lower, ".html") && !strings.HasSuffix(lower, ".htm") {
+			return name + ".html"
+		}
+	case cssContent:
+		if !strings.HasSuffix(lower, ".css") {
+			return name + ".css"
+		}
+	}
+	return name
+}
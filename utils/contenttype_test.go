@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    contentKind
+	}{
+		{"html header", "text/html; charset=utf-8", "Hello", htmlContent},
+		{"xhtml header", "application/xhtml+xml", "", htmlContent},
+		{"css header", "text/css", "body {}", cssContent},
+		{"header wins over body", "text/plain", "<!DOCTYPE html><html></html>", otherContent},
+		{"sniffed html", "", "<!DOCTYPE html><html></html>", htmlContent},
+		{"sniffed octet stream", "application/octet-stream", "<html><body></body></html>", htmlContent},
+		{"sniffed image", "", "\x89PNG\r\n\x1a\n", otherContent},
+		{"invalid header", "text/html;;", "plain words", otherContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectContent(tt.contentType, []byte(tt.body)); got != tt.expected {
+				t.Errorf("detectContent(%q, %q) = %v; want %v", tt.contentType, tt.body, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAdjustExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     contentKind
+		expected string
+	}{
+		{"about", htmlContent, "about.html"},
+		{"page.php?id=1", htmlContent, "page.php?id=1.html"},
+		{"index.HTM", htmlContent, "index.HTM"},
+		{"theme?v=2", cssContent, "theme?v=2.css"},
+		{"site.css", cssContent, "site.css"},
+		{"logo", otherContent, "logo"},
+	}
+	for _, tt := range tests {
+		if got := adjustExtension(tt.name, tt.kind); got != tt.expected {
+			t.Errorf("adjustExtension(%q) = %q; want %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestMirrorWebsiteContentTypes(t *testing.T) {
+	types := map[string]string{
+		"/":       "text/html",
+		"/about":  "text/html",
+		"/theme":  "text/css",
+		"/notes":  "text/plain",
+		"/bg.png": "image/png",
+	}
+	bodies := map[string]string{
+		"/":       `<a href="/about">About</a><a href="/notes">Notes</a><link rel="stylesheet" href="/theme">`,
+		"/about":  `No markup at all`,
+		"/theme":  `body { background: url(/bg.png) }`,
+		"/notes":  `<html>not really a page</html>`,
+		"/bg.png": "PNG",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", types[r.URL.Path])
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	host, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		name     string
+		adjust   bool
+		expected []string
+	}{
+		{"directories", false, []string{"index.html", "about/index.html", "theme", "notes", "bg.png"}},
+		{"adjust extension", true, []string{"index.html", "about.html", "theme.css", "notes", "bg.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			opts := MirrorOptions{Recursive: true, AdjustExtension: tt.adjust}
+			if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+				t.Fatalf("MirrorWebsite failed: %v", err)
+			}
+			for _, want := range tt.expected {
+				if info, err := os.Stat(filepath.Join(host.Host, want)); err != nil || info.IsDir() {
+					t.Errorf("expected file %s to be mirrored: %v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"bytes"
+)
+
+// cssRef is a URL referenced from CSS, by url() or @import. Start and End are the byte
+// offsets of the raw URL, without quotes, so it can be rewritten in place.
+type cssRef struct {
+	URL    string
+	Start  int
+	End    int
+	Import bool
+}
+
+// scanCSS returns the url() and @import references of a stylesheet in source order.
+// Comments are skipped, and so are strings that are not the target of an @import.
+func scanCSS(css []byte) []cssRef {
+	var refs []cssRef
+	i := 0
+	for i < len(css) {
+		switch c := css[i]; {
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := bytes.Index(css[i+2:], []byte("*/"))
+			if end < 0 {
+				return refs
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			_, _, i = cssString(css, i)
+		case c == '@' && hasPrefixFold(css[i:], "@import") && !isCSSNameChar(css, i+len("@import")):
+			i = skipCSSSpace(css, i+len("@import"))
+			if ref, next, ok := cssImportTarget(css, i); ok {
+				refs = append(refs, ref)
+				i = next
+			}
+		case (c == 'u' || c == 'U') && hasPrefixFold(css[i:], "url(") && (i == 0 || !isCSSNameChar(css, i-1)):
+			ref, next, ok := cssURLToken(css, i+len("url("))
+			if ok {
+				refs = append(refs, ref)
+			}
+			i = next
+		default:
+			i++
+		}
+	}
+	return refs
+}
+
+// cssImportTarget reads the string or url() that follows @import
+func cssImportTarget(css []byte, i int) (cssRef, int, bool) {
+	if i >= len(css) {
+		return cssRef{}, i, false
+	}
+	var ref cssRef
+	var next int
+	ok := true
+	switch {
+	case css[i] == '"' || css[i] == '\'':
+		ref.Start, ref.End, next = cssString(css, i)
+		ref.URL = string(css[ref.Start:ref.End])
+	case hasPrefixFold(css[i:], "url("):
+		ref, next, ok = cssURLToken(css, i+len("url("))
+	default:
+		return cssRef{}, i, false
+	}
+	ref.Import = true
+	return ref, next, ok && ref.URL != ""
+}
+
+// cssURLToken reads the inside of url( ... ), starting just after the parenthesis.
+// It returns the position after the closing parenthesis.
+func cssURLToken(css []byte, i int) (cssRef, int, bool) {
+	i = skipCSSSpace(css, i)
+	if i >= len(css) {
+		return cssRef{}, i, false
+	}
+
+	var ref cssRef
+	if css[i] == '"' || css[i] == '\'' {
+		ref.Start, ref.End, i = cssString(css, i)
+		i = skipCSSSpace(css, i)
+	} else {
+		ref.Start = i
+		for i < len(css) && css[i] != ')' && !isSpace(css[i]) {
+			i++
+		}
+		ref.End = i
+		i = skipCSSSpace(css, i)
+	}
+	if i >= len(css) || css[i] != ')' {
+		return cssRef{}, i, false
+	}
+	ref.URL = string(css[ref.Start:ref.End])
+	return ref, i + 1, ref.URL != ""
+}
+
+// cssString reads the quoted string starting at i. It returns the offsets of its
+// contents and the position after the closing quote.
+func cssString(css []byte, i int) (start, end, next int) {
+	quote := css[i]
+	start = i + 1
+	for j := start; j < len(css); j++ {
+		switch css[j] {
+		case '\\':
+			j++
+		case quote, '\n':
+			return start, j, j + 1
+		}
+	}
+	return start, len(css), len(css)
+}
+
+// skipCSSSpace returns the position of the first non-space byte at or after i
+func skipCSSSpace(css []byte, i int) int {
+	for i < len(css) && isSpace(css[i]) {
+		i++
+	}
+	return i
+}
+
+// isCSSNameChar reports whether css[i] could continue an identifier, so that
+// "my-url(" or "@imports" are not taken for url() or @import
+func isCSSNameChar(css []byte, i int) bool {
+	if i < 0 || i >= len(css) {
+		return false
+	}
+	c := css[i]
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '-' || c == '_' || c >= 0x80
+}
+
+// hasPrefixFold reports whether data starts with prefix, ignoring ASCII case
+func hasPrefixFold(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && bytes.EqualFold(data[:len(prefix)], []byte(prefix))
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestScanCSS(t *testing.T) {
+	css := `@import "base.css";
+@import url('print.css') print;
+@IMPORT url(theme.css);
+/* url(commented.png) @import "commented.css"; */
+body { background: URL( "img/bg.png" ) no-repeat; }
+.icon { background-image: url(img/icon.svg#a); content: "url(not-a-link.png)"; }
+.x { my-url(ignored.png); }
+@font-face { src: url(data:font/woff2;base64,AAAA) format("woff2"), url('fonts/a b.woff'); }
+.broken { background: url(`
+
+	expected := []cssRef{
+		{URL: "base.css", Import: true},
+		{URL: "print.css", Import: true},
+		{URL: "theme.css", Import: true},
+		{URL: "img/bg.png"},
+		{URL: "img/icon.svg#a"},
+		{URL: "data:font/woff2;base64,AAAA"},
+		{URL: "fonts/a b.woff"},
+	}
+
+	refs := scanCSS([]byte(css))
+	if len(refs) != len(expected) {
+		t.Fatalf("found %d references %+v; want %d", len(refs), refs, len(expected))
+	}
+	for i, ref := range refs {
+		if ref.URL != expected[i].URL || ref.Import != expected[i].Import {
+			t.Errorf("reference %d = %+v; want %+v", i, ref, expected[i])
+		}
+		if raw := css[ref.Start:ref.End]; raw != ref.URL {
+			t.Errorf("reference %d offsets cover %q; want %q", i, raw, ref.URL)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +15,18 @@ import (
 var downloadWg sync.WaitGroup
 
 func DownloadFile(urlStr, fileName string, background bool, rateLimit int64) error {
+	return DownloadFileShared(urlStr, fileName, background, NewRateLimiter(rateLimit))
+}
+
+// DownloadFileShared downloads urlStr to fileName, drawing bandwidth from limiter.
+// The limiter may be shared between concurrent downloads; nil means unlimited.
+func DownloadFileShared(urlStr, fileName string, background bool, limiter *RateLimiter) error {
+	return downloadWithBudget(urlStr, fileName, background, limiter, nil)
+}
+
+// downloadWithBudget is DownloadFileShared counting the bytes against budget, which
+// may be shared too. A file over its --max-filesize is aborted and removed.
+func downloadWithBudget(urlStr, fileName string, background bool, limiter *RateLimiter, budget *downloadBudget) error {
 	startTime := time.Now().Format("2006-01-02 15:04:05")
 	fmt.Printf("start at %s\n", startTime)
 
@@ -45,6 +58,9 @@ func DownloadFile(urlStr, fileName string, background bool, rateLimit int64) err
 	} else {
 		fmt.Printf("content size: %d [~%.2fMB]\n", contentLength, float64(contentLength)/1000/1000)
 	}
+	if err := budget.checkSize(resp.ContentLength); err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
 
 	out, err := os.Create(fileName)
 	if err != nil {
@@ -55,24 +71,27 @@ func DownloadFile(urlStr, fileName string, background bool, rateLimit int64) err
 	fmt.Printf("saving file to: ./%s\n", fileName)
 
 	var reader io.Reader = resp.Body
-	if rateLimit > 0 {
-		fmt.Printf("Rate limit set to: %.2f KB/s\n", float64(rateLimit)/1024)
-		reader = NewRateLimitReader(resp.Body, rateLimit)
+	if limiter != nil {
+		fmt.Printf("Rate limit set to: %.2f KB/s\n", float64(limiter.Rate())/1024)
+		reader = NewSharedRateLimitReader(resp.Body, limiter)
 	}
+	reader = budget.reader(reader)
 
 	if background {
-		_, err := io.Copy(out, reader)
-		if err != nil {
-			return fmt.Errorf("error: %v", err)
-		}
+		_, err = io.Copy(out, reader)
 	} else {
 		bar := NewProgressBar(contentLength, 50)
 		bar.StartTimer()
 
 		_, err = io.Copy(io.MultiWriter(out, bar), reader)
-		if err != nil {
-			return fmt.Errorf("error: %v", err)
-		}
+	}
+	if errors.Is(err, errFileTooLarge) {
+		// Nothing of an oversize file is kept
+		out.Close()
+		os.Remove(fileName)
+	}
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
 	}
 
 	endTime := time.Now().Format("2006-01-02 15:04:05")
@@ -81,65 +100,79 @@ func DownloadFile(urlStr, fileName string, background bool, rateLimit int64) err
 	return nil
 }
 
-func DownloadWithLogging(urlStr string, fileName string, background bool, rateLimit int64) {
+// DownloadWithLogging downloads a single file, in the background with -B. A file over
+// maxFileSize bytes is not kept; 0 means no limit.
+func DownloadWithLogging(urlStr string, fileName string, background bool, rateLimit int64, maxFileSize int64) {
+	download := func(background bool) error {
+		budget := newDownloadBudget(Limits{MaxFileSize: maxFileSize})
+		return downloadWithBudget(urlStr, fileName, background, NewRateLimiter(rateLimit), budget)
+	}
 	if background {
-		// Check if this is the child process
-		if len(os.Args) > 1 && os.Args[len(os.Args)-1] == "background-download" {
-			// Open log file with O_TRUNC flag instead of O_APPEND to clear existing content
-			logFile, err := os.OpenFile("wget-log", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				return
-			}
-			defer logFile.Close()
-
-			// Redirect stdout to log file
-			os.Stdout = logFile
-			os.Stderr = logFile
-
-			// Perform the download
-			err = DownloadFile(urlStr, fileName, true, rateLimit)
-			if err != nil {
-				fmt.Fprintf(logFile, "Error: %v\n", err)
-			}
-			return
+		RunInBackground(func() error {
+			return download(true)
+		})
+	} else {
+		err := download(background)
+		if err != nil {
+			fmt.Println(err)
 		}
+	}
+}
 
-		// This is the parent process
-		fmt.Println("Output will be written to \"wget-log\".")
-
-		// Get the path to the current executable
-		executable, err := os.Executable()
+// RunInBackground detaches task from the terminal. The parent process re-executes
+// itself with a marker argument and returns immediately; the child recognises the
+// marker, redirects its output to "wget-log" and runs task.
+func RunInBackground(task func() error) {
+	// Check if this is the child process
+	if len(os.Args) > 1 && os.Args[len(os.Args)-1] == "background-download" {
+		// Open log file with O_TRUNC flag instead of O_APPEND to clear existing content
+		logFile, err := os.OpenFile("wget-log", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			fmt.Printf("Error getting executable path: %v\n", err)
 			return
 		}
+		defer logFile.Close()
 
-		// Create command for the child process
-		args := append([]string{}, os.Args[1:]...)    // Copy original args
-		args = append(args, "background-download")     // Add background flag
-		cmd := exec.Command(executable, args...)
-
-		// Detach the process from terminal
-		cmd.Stdin = nil
-		cmd.Stdout = nil
-		cmd.Stderr = nil
+		// Redirect stdout to log file
+		os.Stdout = logFile
+		os.Stderr = logFile
 
-		// Start the detached process
-		err = cmd.Start()
+		// Perform the task
+		err = task()
 		if err != nil {
-			fmt.Printf("Error starting background process: %v\n", err)
-			return
+			fmt.Fprintf(logFile, "Error: %v\n", err)
 		}
+		return
+	}
 
-		// Detach it from the parent
-		cmd.Process.Release()
+	// This is the parent process
+	fmt.Println("Output will be written to \"wget-log\".")
 
-	} else {
-		err := DownloadFile(urlStr, fileName, background, rateLimit)
-		if err != nil {
-			fmt.Println(err)
-		}
+	// Get the path to the current executable
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error getting executable path: %v\n", err)
+		return
 	}
+
+	// Create command for the child process
+	args := append([]string{}, os.Args[1:]...) // Copy original args
+	args = append(args, "background-download") // Add background flag
+	cmd := exec.Command(executable, args...)
+
+	// Detach the process from terminal
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+
+	// Start the detached process
+	err = cmd.Start()
+	if err != nil {
+		fmt.Printf("Error starting background process: %v\n", err)
+		return
+	}
+
+	// Detach it from the parent
+	cmd.Process.Release()
 }
 
 func GetFileName(url string) string {
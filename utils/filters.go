@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// fileFilter decides which URLs a mirror downloads, from the -A/-R name lists, the
+// --accept-regex/--reject-regex URL patterns and the -I/-X directory lists.
+type fileFilter struct {
+	accept      []string
+	reject      []string
+	acceptRegex *regexp.Regexp
+	rejectRegex *regexp.Regexp
+	include     []string
+	exclude     []string
+}
+
+// newFileFilter builds the filter described by opts. It fails only if one of the
+// regular expressions does not compile.
+func newFileFilter(opts MirrorOptions) (*fileFilter, error) {
+	f := &fileFilter{
+		accept:  opts.Accept,
+		reject:  opts.Reject,
+		include: opts.Include,
+		exclude: opts.Exclude,
+	}
+
+	var err error
+	if opts.AcceptRegex != "" {
+		if f.acceptRegex, err = regexp.Compile(opts.AcceptRegex); err != nil {
+			return nil, fmt.Errorf("invalid --accept-regex: %v", err)
+		}
+	}
+	if opts.RejectRegex != "" {
+		if f.rejectRegex, err = regexp.Compile(opts.RejectRegex); err != nil {
+			return nil, fmt.Errorf("invalid --reject-regex: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// allowsURL checks the regular expressions, which are matched against the full URL,
+// and the directory lists
+func (f *fileFilter) allowsURL(fileURL string) bool {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return false
+	}
+
+	if f.acceptRegex != nil && !f.acceptRegex.MatchString(fileURL) {
+		return false
+	}
+	if f.rejectRegex != nil && f.rejectRegex.MatchString(fileURL) {
+		return false
+	}
+
+	dir := path.Dir(u.Path)
+	if strings.HasSuffix(u.Path, "/") {
+		dir = path.Clean(u.Path)
+	}
+	if len(f.include) > 0 && !matchesAnyDir(dir, f.include) {
+		return false
+	}
+	return !matchesAnyDir(dir, f.exclude)
+}
+
+// allowsName checks the -A and -R lists against the file name of fileURL. A URL
+// naming a directory has no file name and always passes.
+func (f *fileFilter) allowsName(fileURL string) bool {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return false
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return true
+	}
+
+	name := path.Base(u.Path)
+	if len(f.accept) > 0 && !matchesAnyName(name, f.accept) {
+		return false
+	}
+	return !matchesAnyName(name, f.reject)
+}
+
+// matchesAnyName reports whether name matches one of patterns. A pattern containing
+// wildcards is a glob matched against the whole name; any other pattern is a suffix,
+// so "js" matches "app.js" but neither "data.json" nor "jsdocs".
+func matchesAnyName(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if isGlob(pattern) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			continue
+		}
+		suffix := strings.TrimPrefix(pattern, ".")
+		if name == pattern || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyDir reports whether dir is, or is inside, one of the directories in
+// patterns. Directory components may be globs, so "/docs/*" matches "/docs/v2/api".
+func matchesAnyDir(dir string, patterns []string) bool {
+	dirParts := strings.Split(strings.Trim(dir, "/"), "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if pattern == "" {
+			return true
+		}
+		patternParts := strings.Split(pattern, "/")
+		if len(patternParts) > len(dirParts) {
+			continue
+		}
+
+		matched := true
+		for i, part := range patternParts {
+			if ok, _ := path.Match(part, dirParts[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isGlob reports whether pattern uses any wildcard characters
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// looksLikePage reports whether fileURL probably names an HTML page, which -A and -R
+// must not stop a recursive crawl from reading
+func looksLikePage(fileURL string) bool {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	return strings.HasSuffix(u.Path, "/") || ext == "" || ext == ".html" || ext == ".htm"
+}
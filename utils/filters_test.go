@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     MirrorOptions
+		url      string
+		expected bool
+	}{
+		{"no filters", MirrorOptions{}, "http://example.com/app.js", true},
+		{"reject suffix", MirrorOptions{Reject: []string{"js"}}, "http://example.com/app.js", false},
+		{"reject suffix not substring", MirrorOptions{Reject: []string{"js"}}, "http://example.com/data.json", true},
+		{"reject suffix not directory", MirrorOptions{Reject: []string{"js"}}, "http://example.com/jsdocs/index.html", true},
+		{"reject dotted suffix", MirrorOptions{Reject: []string{".gif"}}, "http://example.com/a.gif", false},
+		{"reject glob", MirrorOptions{Reject: []string{"thumb-*"}}, "http://example.com/img/thumb-1.png", false},
+		{"accept suffix", MirrorOptions{Accept: []string{"pdf"}}, "http://example.com/paper.pdf", true},
+		{"accept refuses others", MirrorOptions{Accept: []string{"pdf"}}, "http://example.com/paper.doc", false},
+		{"accept ignores directories", MirrorOptions{Accept: []string{"pdf"}}, "http://example.com/papers/", true},
+		{"accept glob", MirrorOptions{Accept: []string{"report-*.csv"}}, "http://example.com/report-2024.csv", true},
+		{"accept regex", MirrorOptions{AcceptRegex: `/docs/`}, "http://example.com/blog/a.html", false},
+		{"reject regex on query", MirrorOptions{RejectRegex: `\?sort=`}, "http://example.com/list?sort=asc", false},
+		{"include directory", MirrorOptions{Include: []string{"/docs"}}, "http://example.com/docs/v2/api.html", true},
+		{"include refuses others", MirrorOptions{Include: []string{"/docs"}}, "http://example.com/blog/a.html", false},
+		{"include is not a prefix", MirrorOptions{Include: []string{"/docs"}}, "http://example.com/docs2/a.html", false},
+		{"include glob", MirrorOptions{Include: []string{"/blog/*"}}, "http://example.com/blog/2024/a.html", true},
+		{"exclude directory", MirrorOptions{Exclude: []string{"/assets"}}, "http://example.com/assets/a.png", false},
+		{"exclude named directory URL", MirrorOptions{Exclude: []string{"/assets"}}, "http://example.com/assets/", false},
+		{"exclude keeps siblings", MirrorOptions{Exclude: []string{"/assets"}}, "http://example.com/assets.png", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFileFilter(tt.opts)
+			if err != nil {
+				t.Fatalf("newFileFilter failed: %v", err)
+			}
+			if got := f.allowsURL(tt.url) && f.allowsName(tt.url); got != tt.expected {
+				t.Errorf("filter allows %q = %v; want %v", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewFileFilterInvalidRegex(t *testing.T) {
+	if _, err := newFileFilter(MirrorOptions{RejectRegex: "("}); err == nil {
+		t.Errorf("expected an error for an invalid --reject-regex")
+	}
+}
+
+func TestMirrorWebsiteRejectedPagesAreTraversed(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":          `<html><body><a href="/list.html">List</a></body></html>`,
+		"/list.html": `<html><body><a href="/paper.pdf">Paper</a></body></html>`,
+		"/paper.pdf": "PDF",
+		"/notes.txt": "TXT",
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, Accept: []string{"pdf"}})
+	if err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	if _, err := os.Stat(filepath.Join(host.Host, "paper.pdf")); err != nil {
+		t.Errorf("paper.pdf is linked from a rejected page and should be mirrored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(host.Host, "list.html")); !os.IsNotExist(err) {
+		t.Errorf("list.html should be deleted after the crawl")
+	}
+}
@@ -4,51 +4,217 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func CheckFlags() (output, url string, tolog bool, file string, rateLimit int64, mirror bool, reject, exclude []string, convertLinks bool, path string, err error) {
+// Options holds everything parsed from the command line. The mirror settings are
+// embedded so they can be handed to MirrorWebsite as a whole.
+type Options struct {
+	Output     string
+	URL        string
+	Background bool
+	InputFile  string
+	Path       string
+	Concat     bool
+	Jobs       int
+	Mirror     bool
+	MirrorOptions
+}
+
+func CheckFlags() (*Options, error) {
 	outputFile := flag.String("O", "", "Specify the output filename")
 	log := flag.Bool("B", false, "Run download in the background")
 	inputFile := flag.String("i", "", "Download multiple files from a list of URLs")
 	rateLimitFlag := flag.String("rate-limit", "", "Limit download speed (e.g., 400k, 2M)")
 	pathFlag := flag.String("P", "", "Specify the directory path for downloads") // New path flag
+	jobsFlag := flag.Int("jobs", DefaultJobs, "Number of -i downloads to run at the same time")
+	concatFlag := flag.Bool("concat", false, "Join all files downloaded with -i into the single -O file, in input order")
 
 	// New flags
 	mirrorFlag := flag.Bool("mirror", false, "Mirror the entire website")
-	rejectFlag := flag.String("R", "", "Reject file suffixes (comma-separated)")
+	recursiveFlag := flag.Bool("r", false, "Recursively download linked pages")
+	levelFlag := flag.String("l", strconv.Itoa(DefaultLevel), "Maximum recursion depth (inf or 0 for unlimited)")
+	requisitesFlag := flag.Bool("p", false, "Download everything needed to display the page offline")
+	spanHostsFlag := flag.Bool("H", false, "Follow links to other hosts")
+	domainsFlag := flag.String("D", "", "Only span to these domains and their subdomains (comma-separated)")
+	excludeDomainsFlag := flag.String("exclude-domains", "", "Never download from these domains (comma-separated)")
+	acceptFlag := flag.String("A", "", "Accept only these file suffixes or globs (comma-separated)")
+	rejectFlag := flag.String("R", "", "Reject file suffixes or globs (comma-separated)")
+	acceptRegexFlag := flag.String("accept-regex", "", "Accept only URLs matching this regular expression")
+	rejectRegexFlag := flag.String("reject-regex", "", "Reject URLs matching this regular expression")
+	includeFlag := flag.String("I", "", "Include only these directories (comma-separated)")
 	excludeFlag := flag.String("X", "", "Exclude directories (comma-separated)")
 	convertLinksFlag := flag.Bool("convert-links", false, "Convert links for offline viewing")
+	waitFlag := flag.Float64("wait", 0, "Seconds to wait between requests to the same host while mirroring")
+	randomWaitFlag := flag.Bool("random-wait", false, "Vary --wait between 0.5 and 1.5 times its value")
+	maxPerHostFlag := flag.Int("max-per-host", DefaultMaxPerHost, "Maximum concurrent connections to one host while mirroring")
+	restrictFlag := flag.String("restrict-file-names", "", "Escape characters in saved file names: unix, windows, ascii, lowercase (comma-separated)")
+	adjustFlag := flag.Bool("E", false, "Add .html or .css to pages and stylesheets saved without that extension")
+	noDirsFlag := flag.Bool("nd", false, "Save every mirrored file in one folder")
+	noHostDirsFlag := flag.Bool("nH", false, "Do not create a folder per host when mirroring")
+	cutDirsFlag := flag.Int("cut-dirs", 0, "Drop this many leading directories from mirrored paths")
+	protocolDirsFlag := flag.Bool("protocol-directories", false, "Put host folders in a folder named after the scheme")
+	sitemapFlag := flag.Bool("sitemap", false, "Also crawl the pages listed in the site's sitemaps")
+	continueFlag := flag.Bool("c", false, "Resume an interrupted mirror from its journal")
+	deleteAfterSyncFlag := flag.Bool("delete-after-sync", false, "After a complete mirror, delete the files it did not produce")
+	syncDryRunFlag := flag.Bool("sync-dry-run", false, "With --delete-after-sync, only list the files that would be deleted")
+	spiderFlag := flag.Bool("spider", false, "Check links without saving anything and report the broken ones")
+	syncMaxDeleteFlag := flag.String("sync-max-delete", "", "With --delete-after-sync, the most files to delete, as a count or a percentage (default 50%)")
+	quotaFlag := flag.String("quota", "", "Stop starting downloads once this much was downloaded (e.g., 500M)")
+	maxPagesFlag := flag.Int("max-pages", 0, "Stop after this many mirrored pages or -i files")
+	maxTimeFlag := flag.String("max-time", "", "Stop starting downloads after this long, in seconds or as a duration (e.g., 10m)")
+	maxFileSizeFlag := flag.String("max-filesize", "", "Skip files larger than this (e.g., 50M)")
+	var commands commandList
+	flag.Var(&commands, "e", "Run a wgetrc-style command such as robots=off (repeatable)")
 
 	// Long-form versions of short flags
-	flag.StringVar(rejectFlag, "reject", "", "Reject file suffixes (comma-separated)")
+	flag.StringVar(acceptFlag, "accept", "", "Accept only these file suffixes or globs (comma-separated)")
+	flag.StringVar(rejectFlag, "reject", "", "Reject file suffixes or globs (comma-separated)")
+	flag.StringVar(includeFlag, "include-directories", "", "Include only these directories (comma-separated)")
 	flag.StringVar(excludeFlag, "exclude", "", "Exclude directories (comma-separated)")
+	flag.StringVar(excludeFlag, "exclude-directories", "", "Exclude directories (comma-separated)")
+	flag.BoolVar(recursiveFlag, "recursive", false, "Recursively download linked pages")
+	flag.StringVar(levelFlag, "level", strconv.Itoa(DefaultLevel), "Maximum recursion depth (inf or 0 for unlimited)")
+	flag.BoolVar(requisitesFlag, "page-requisites", false, "Download everything needed to display the page offline")
+	flag.BoolVar(spanHostsFlag, "span-hosts", false, "Follow links to other hosts")
+	flag.StringVar(domainsFlag, "domains", "", "Only span to these domains and their subdomains (comma-separated)")
+	flag.StringVar(pathFlag, "directory-prefix", "", "Specify the directory path for downloads")
+	flag.BoolVar(noDirsFlag, "no-directories", false, "Save every mirrored file in one folder")
+	flag.BoolVar(noHostDirsFlag, "no-host-directories", false, "Do not create a folder per host when mirroring")
+	flag.BoolVar(adjustFlag, "adjust-extension", false, "Add .html or .css to pages and stylesheets saved without that extension")
+	flag.BoolVar(continueFlag, "continue", false, "Resume an interrupted mirror from its journal")
+	flag.StringVar(quotaFlag, "Q", "", "Stop starting downloads once this much was downloaded (e.g., 500M)")
+	flag.Var(&commands, "execute", "Run a wgetrc-style command such as robots=off (repeatable)")
 
 	flag.Parse()
 
 	// Check for incompatible flag combinations when mirror flag is used
-	if *mirrorFlag {
-		if *outputFile != "" {
-			return "", "", false, "", 0, false, nil, nil, false, "", fmt.Errorf("cannot use -O flag with --mirror")
+	if *mirrorFlag || *recursiveFlag || *requisitesFlag || *spiderFlag {
+		mode := "--mirror"
+		if !*mirrorFlag {
+			mode = "-r"
+			if !*recursiveFlag {
+				mode = "-p"
+				if !*requisitesFlag {
+					mode = "--spider"
+				}
+			}
 		}
-		if *log {
-			return "", "", false, "", 0, false, nil, nil, false, "", fmt.Errorf("cannot use -B flag with --mirror")
+		if *outputFile != "" {
+			return nil, fmt.Errorf("cannot use -O flag with %s", mode)
 		}
 		if *inputFile != "" {
-			return "", "", false, "", 0, false, nil, nil, false, "", fmt.Errorf("cannot use -i flag with --mirror")
+			return nil, fmt.Errorf("cannot use -i flag with %s", mode)
+		}
+		if *concatFlag {
+			return nil, fmt.Errorf("cannot use --concat flag with %s", mode)
+		}
+	} else if *sitemapFlag {
+		return nil, fmt.Errorf("--sitemap requires --mirror, -r or -p")
+	} else if *continueFlag {
+		return nil, fmt.Errorf("--continue requires --mirror, -r or -p")
+	} else if *deleteAfterSyncFlag {
+		return nil, fmt.Errorf("--delete-after-sync requires --mirror or -r -l inf")
+	}
+	if *spiderFlag {
+		// A spider saves nothing, so there is nothing to convert, resume or prune
+		switch {
+		case *convertLinksFlag:
+			return nil, fmt.Errorf("cannot use --convert-links with --spider")
+		case *continueFlag:
+			return nil, fmt.Errorf("cannot use --continue with --spider")
+		case *deleteAfterSyncFlag:
+			return nil, fmt.Errorf("cannot use --delete-after-sync with --spider")
+		}
+	}
+	if !*deleteAfterSyncFlag && (*syncDryRunFlag || *syncMaxDeleteFlag != "") {
+		return nil, fmt.Errorf("--sync-dry-run and --sync-max-delete require --delete-after-sync")
+	}
+	if _, err := parseSyncLimit(*syncMaxDeleteFlag); err != nil {
+		return nil, err
+	}
+
+	// The run-wide limits only mean something when there is more than one download
+	if (*quotaFlag != "" || *maxPagesFlag != 0 || *maxTimeFlag != "") && *inputFile == "" && !(*mirrorFlag || *recursiveFlag || *requisitesFlag || *spiderFlag) {
+		return nil, fmt.Errorf("--quota, --max-pages and --max-time require -i, --mirror, -r or -p")
+	}
+	if *maxPagesFlag < 0 {
+		return nil, fmt.Errorf("--max-pages cannot be negative")
+	}
+	quota, err := ParseSize(*quotaFlag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --quota: %v", err)
+	}
+	maxTime, err := parseDuration(*maxTimeFlag)
+	if err != nil {
+		return nil, err
+	}
+	maxFileSize, err := ParseSize(*maxFileSizeFlag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --max-filesize: %v", err)
+	}
+
+	level, err := parseLevel(*levelFlag)
+	if err != nil {
+		return nil, err
+	}
+	// --mirror implies -r with no depth limit unless -l says otherwise
+	if *mirrorFlag && !isFlagSet("l", "level") {
+		level = 0
+	}
+
+	if *waitFlag < 0 {
+		return nil, fmt.Errorf("--wait cannot be negative")
+	}
+	if _, err := parseRestrictFileNames(*restrictFlag); err != nil {
+		return nil, err
+	}
+	if *cutDirsFlag < 0 {
+		return nil, fmt.Errorf("--cut-dirs cannot be negative")
+	}
+	if *maxPerHostFlag < 1 {
+		return nil, fmt.Errorf("--max-per-host must be at least 1")
+	}
+
+	if *jobsFlag < 1 {
+		return nil, fmt.Errorf("--jobs must be at least 1")
+	}
+
+	// --concat assembles the bodies of an -i list into the file named by -O
+	if *concatFlag {
+		if *inputFile == "" {
+			return nil, fmt.Errorf("--concat requires -i")
 		}
-		if *rateLimitFlag != "" {
-			return "", "", false, "", 0, false, nil, nil, false, "", fmt.Errorf("cannot use --rate-limit flag with --mirror")
+		if *outputFile == "" {
+			return nil, fmt.Errorf("--concat requires -O")
 		}
 	}
 
+	opts := &Options{
+		Output:     *outputFile,
+		Background: *log,
+		InputFile:  *inputFile,
+		Path:       *pathFlag,
+		Concat:     *concatFlag,
+		Jobs:       *jobsFlag,
+		Mirror:     *mirrorFlag || *recursiveFlag || *requisitesFlag || *spiderFlag,
+	}
+	opts.Recursive = *mirrorFlag || *recursiveFlag
+	opts.Level = level
+	opts.PageRequisites = *requisitesFlag
+	opts.SpanHosts = *spanHostsFlag
+	opts.Domains = removeEmptyStrings(strings.Split(*domainsFlag, ","))
+	opts.ExcludeDomains = removeEmptyStrings(strings.Split(*excludeDomainsFlag, ","))
+
 	if *inputFile == "" {
-		if flag.NArg() < 1 && !*mirrorFlag {
-			fmt.Println("Usage: go run . [-O filename] [-P path] [-B] [-i urlfile] [--rate-limit rate] [--mirror] [-R suffixes] [-X directories] [--convert-links] <URL>")
-			return "", "", false, "", 0, false, nil, nil, false, "", fmt.Errorf("missing URL argument")
+		if flag.NArg() < 1 && !opts.Mirror {
+			fmt.Println("Usage: go run . [-O filename] [-P path] [-B] [-i urlfile] [--rate-limit rate] [--jobs n] [--concat] [--mirror] [-r] [-l depth] [-p] [-H] [-D domains] [--exclude-domains domains] [-A suffixes] [-R suffixes] [--accept-regex re] [--reject-regex re] [-I directories] [-X directories] [--convert-links] [--wait seconds] [--random-wait] [--max-per-host n] [-e robots=off] [--sitemap] [-c] [--delete-after-sync] [--sync-dry-run] [--sync-max-delete n|n%] [--spider] [--quota size] [--max-pages n] [--max-time duration] [--max-filesize size] [--restrict-file-names modes] [-E] [-nd] [-nH] [--cut-dirs n] [--protocol-directories] <URL>")
+			return nil, fmt.Errorf("missing URL argument")
 		}
 		if flag.NArg() > 0 {
-			url = flag.Arg(0)
+			opts.URL = flag.Arg(0)
 		}
 	}
 
@@ -56,20 +222,112 @@ func CheckFlags() (output, url string, tolog bool, file string, rateLimit int64,
 	if err != nil {
 		fmt.Printf("Warning: Invalid rate limit format: %v\n", err)
 	}
+	opts.RateLimit = limit
 
 	// Process new flags
-	reject = strings.Split(*rejectFlag, ",")
-	exclude = strings.Split(*excludeFlag, ",")
-	reject = removeEmptyStrings(reject)
-	exclude = removeEmptyStrings(exclude)
+	opts.Accept = removeEmptyStrings(strings.Split(*acceptFlag, ","))
+	opts.Reject = removeEmptyStrings(strings.Split(*rejectFlag, ","))
+	opts.AcceptRegex = *acceptRegexFlag
+	opts.RejectRegex = *rejectRegexFlag
+	opts.Include = removeEmptyStrings(strings.Split(*includeFlag, ","))
+	opts.Exclude = removeEmptyStrings(strings.Split(*excludeFlag, ","))
+	opts.ConvertLinks = *convertLinksFlag
+	opts.Wait = time.Duration(*waitFlag * float64(time.Second))
+	opts.RandomWait = *randomWaitFlag
+	opts.MaxPerHost = *maxPerHostFlag
+	opts.Sitemap = *sitemapFlag
+	opts.Continue = *continueFlag
+	opts.DeleteAfterSync = *deleteAfterSyncFlag
+	opts.SyncDryRun = *syncDryRunFlag
+	opts.SyncMaxDelete = *syncMaxDeleteFlag
+	opts.Spider = *spiderFlag
+	opts.Quota = quota
+	opts.MaxPages = *maxPagesFlag
+	opts.MaxTime = maxTime
+	opts.MaxFileSize = maxFileSize
+	opts.RestrictFileNames = *restrictFlag
+	opts.AdjustExtension = *adjustFlag
+	if err := applyCommands(opts, commands); err != nil {
+		return nil, err
+	}
+
+	opts.NoDirectories = *noDirsFlag
+	opts.NoHostDirectories = *noHostDirsFlag
+	opts.CutDirs = *cutDirsFlag
+	opts.ProtocolDirectories = *protocolDirsFlag
 
 	// Expand "~" in path if necessary
-	if *pathFlag != "" && strings.HasPrefix(*pathFlag, "~") {
+	if opts.Path != "" && strings.HasPrefix(opts.Path, "~") {
 		home := os.Getenv("HOME")
-		*pathFlag = strings.Replace(*pathFlag, "~", home, 1)
+		opts.Path = strings.Replace(opts.Path, "~", home, 1)
 	}
+	opts.DirectoryPrefix = opts.Path
+
+	return opts, nil
+}
+
+// commandList collects the values of a repeatable flag
+type commandList []string
+
+func (c *commandList) String() string {
+	return strings.Join(*c, ",")
+}
 
-	return *outputFile, url, *log, *inputFile, limit, *mirrorFlag, reject, exclude, *convertLinksFlag, *pathFlag, nil
+func (c *commandList) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+// applyCommands applies -e commands, written name=value as in a wgetrc file
+func applyCommands(opts *Options, commands []string) error {
+	for _, command := range commands {
+		name, value, ok := strings.Cut(command, "=")
+		if !ok {
+			return fmt.Errorf("invalid command %q, expected name=value", command)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		switch name {
+		case "robots":
+			switch value {
+			case "on":
+				opts.IgnoreRobots = false
+			case "off":
+				opts.IgnoreRobots = true
+			default:
+				return fmt.Errorf("invalid value %q for robots, expected on or off", value)
+			}
+		default:
+			return fmt.Errorf("unknown command %q", name)
+		}
+	}
+	return nil
+}
+
+// parseLevel converts the -l value to a depth, where "inf" and "0" both mean unlimited
+func parseLevel(value string) (int, error) {
+	if strings.EqualFold(value, "inf") {
+		return 0, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 {
+		return 0, fmt.Errorf("invalid recursion depth %q", value)
+	}
+	return level, nil
+}
+
+// isFlagSet reports whether any of the named flags was given on the command line
+func isFlagSet(names ...string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
+			}
+		}
+	})
+	return set
 }
 
 func removeEmptyStrings(s []string) []string {
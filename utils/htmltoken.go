@@ -0,0 +1,669 @@
+package utils
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// htmlTokenType identifies the kind of token produced by htmlTokenizer
+type htmlTokenType int
+
+const (
+	textToken htmlTokenType = iota
+	startTagToken
+	endTagToken
+	commentToken
+	doctypeToken
+)
+
+// htmlAttr is one attribute of a start tag. ValueStart and ValueEnd are byte offsets
+// of the raw value in the document, excluding any quotes.
+type htmlAttr struct {
+	Name       string // lowercased
+	Value      string // with character references decoded
+	ValueStart int
+	ValueEnd   int
+	Quote      byte // '"', '\'' or 0 for an unquoted or missing value
+}
+
+// htmlToken is a single piece of an HTML document. Start and End are the byte offsets
+// of the whole token; for text tokens they delimit the text itself.
+type htmlToken struct {
+	Type  htmlTokenType
+	Tag   string // lowercased tag name for start and end tags
+	Attrs []htmlAttr
+	Start int
+	End   int
+}
+
+// htmlTokenizer splits an HTML document into tags, text and comments in a single pass.
+// It is deliberately forgiving: malformed markup never stops it, it just becomes text.
+// The contents of <script> and <style> are returned as one raw text token so markup
+// inside them is never mistaken for tags.
+type htmlTokenizer struct {
+	data    []byte
+	pos     int
+	rawText string // tag whose raw content comes next, if any
+}
+
+func newHTMLTokenizer(data []byte) *htmlTokenizer {
+	return &htmlTokenizer{data: data}
+}
+
+// Next returns the next token, or false once the document is exhausted
+func (z *htmlTokenizer) Next() (htmlToken, bool) {
+	if z.pos >= len(z.data) {
+		return htmlToken{}, false
+	}
+
+	if z.rawText != "" {
+		return z.readRawText(), true
+	}
+
+	start := z.pos
+	if z.data[start] != '<' {
+		return z.readText(), true
+	}
+
+	rest := z.data[start:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("<!--")):
+		end := bytes.Index(rest[4:], []byte("-->"))
+		if end < 0 {
+			z.pos = len(z.data)
+		} else {
+			z.pos = start + 4 + end + 3
+		}
+		return htmlToken{Type: commentToken, Start: start, End: z.pos}, true
+	case len(rest) > 1 && (rest[1] == '!' || rest[1] == '?'):
+		// Doctype, CDATA and processing instructions run to the next '>'
+		z.skipPast('>')
+		return htmlToken{Type: doctypeToken, Start: start, End: z.pos}, true
+	case len(rest) > 2 && rest[1] == '/' && isASCIILetter(rest[2]):
+		z.pos += 2
+		name := z.readTagName()
+		z.skipPast('>')
+		return htmlToken{Type: endTagToken, Tag: name, Start: start, End: z.pos}, true
+	case len(rest) > 1 && isASCIILetter(rest[1]):
+		return z.readStartTag(), true
+	}
+
+	// A '<' that does not open a tag is plain text
+	z.pos++
+	tok := z.readText()
+	tok.Start = start
+	return tok, true
+}
+
+// readText reads up to the next '<'
+func (z *htmlTokenizer) readText() htmlToken {
+	start := z.pos
+	end := bytes.IndexByte(z.data[start:], '<')
+	if end < 0 {
+		z.pos = len(z.data)
+	} else {
+		z.pos = start + end
+	}
+	return htmlToken{Type: textToken, Start: start, End: z.pos}
+}
+
+// readRawText reads the contents of a script or style element up to its end tag
+func (z *htmlTokenizer) readRawText() htmlToken {
+	start := z.pos
+	closing := []byte("</" + z.rawText)
+	end := indexFold(z.data[start:], closing)
+	if end < 0 {
+		z.pos = len(z.data)
+	} else {
+		z.pos = start + end
+	}
+	z.rawText = ""
+	return htmlToken{Type: textToken, Start: start, End: z.pos}
+}
+
+// readStartTag reads a start tag with its attributes, positioned at the '<'
+func (z *htmlTokenizer) readStartTag() htmlToken {
+	tok := htmlToken{Type: startTagToken, Start: z.pos}
+	z.pos++
+	tok.Tag = z.readTagName()
+
+	for {
+		z.skipSpaceAndSlash()
+		if z.pos >= len(z.data) {
+			break
+		}
+		if z.data[z.pos] == '>' {
+			z.pos++
+			break
+		}
+		tok.Attrs = append(tok.Attrs, z.readAttr())
+	}
+	tok.End = z.pos
+
+	if tok.Tag == "script" || tok.Tag == "style" {
+		z.rawText = tok.Tag
+	}
+	return tok
+}
+
+// readTagName reads a tag name and lowercases it
+func (z *htmlTokenizer) readTagName() string {
+	start := z.pos
+	for z.pos < len(z.data) && !isSpace(z.data[z.pos]) && z.data[z.pos] != '/' && z.data[z.pos] != '>' {
+		z.pos++
+	}
+	return strings.ToLower(string(z.data[start:z.pos]))
+}
+
+// readAttr reads one name[=value] pair
+func (z *htmlTokenizer) readAttr() htmlAttr {
+	start := z.pos
+	// The first character is always part of the name, even if it is '='
+	z.pos++
+	for z.pos < len(z.data) && !isSpace(z.data[z.pos]) && z.data[z.pos] != '/' &&
+		z.data[z.pos] != '>' && z.data[z.pos] != '=' {
+		z.pos++
+	}
+	attr := htmlAttr{Name: strings.ToLower(string(z.data[start:z.pos])), ValueStart: z.pos, ValueEnd: z.pos}
+
+	z.skipSpace()
+	if z.pos >= len(z.data) || z.data[z.pos] != '=' {
+		return attr
+	}
+	z.pos++
+	z.skipSpace()
+	if z.pos >= len(z.data) {
+		return attr
+	}
+
+	if q := z.data[z.pos]; q == '"' || q == '\'' {
+		attr.Quote = q
+		z.pos++
+		attr.ValueStart = z.pos
+		end := bytes.IndexByte(z.data[z.pos:], q)
+		if end < 0 {
+			z.pos = len(z.data)
+			attr.ValueEnd = z.pos
+		} else {
+			attr.ValueEnd = z.pos + end
+			z.pos = attr.ValueEnd + 1
+		}
+	} else {
+		attr.ValueStart = z.pos
+		for z.pos < len(z.data) && !isSpace(z.data[z.pos]) && z.data[z.pos] != '>' {
+			z.pos++
+		}
+		attr.ValueEnd = z.pos
+	}
+
+	attr.Value = html.UnescapeString(string(z.data[attr.ValueStart:attr.ValueEnd]))
+	return attr
+}
+
+func (z *htmlTokenizer) skipSpace() {
+	for z.pos < len(z.data) && isSpace(z.data[z.pos]) {
+		z.pos++
+	}
+}
+
+func (z *htmlTokenizer) skipSpaceAndSlash() {
+	for z.pos < len(z.data) && (isSpace(z.data[z.pos]) || z.data[z.pos] == '/') {
+		z.pos++
+	}
+}
+
+// skipPast moves to just after the next c, or to the end of the document
+func (z *htmlTokenizer) skipPast(c byte) {
+	end := bytes.IndexByte(z.data[z.pos:], c)
+	if end < 0 {
+		z.pos = len(z.data)
+	} else {
+		z.pos += end + 1
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// indexFold is bytes.Index ignoring ASCII case
+func indexFold(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// linkKind separates links to other pages from resources needed to render a page
+type linkKind int
+
+const (
+	pageLink      linkKind = iota // <a href>, <area href>, <iframe src>, ...
+	requisiteLink                 // <img src>, <script src>, <link rel=stylesheet>, ...
+)
+
+// htmlLink is a URL found in a document. Start and End are the byte offsets of the
+// raw URL in the document, so it can be rewritten in place.
+type htmlLink struct {
+	Tag   string // tag the URL was found in
+	Attr  string // attribute holding the URL; empty inside a <style> block
+	URL   string // decoded value
+	Kind  linkKind
+	Start int
+	End   int
+	Quote byte
+
+	// Converted is set when URL was put back from what an earlier link conversion
+	// wrote, so the text at the offsets no longer matches it
+	Converted bool
+}
+
+// linkAttrs lists the attributes holding URLs for each tag and what they are used for
+var linkAttrs = map[string]map[string]linkKind{
+	"a":      {"href": pageLink, "xlink:href": pageLink},
+	"area":   {"href": pageLink},
+	"frame":  {"src": pageLink},
+	"iframe": {"src": pageLink},
+	"img":    {"src": requisiteLink, "srcset": requisiteLink},
+	"source": {"src": requisiteLink, "srcset": requisiteLink},
+	"video":  {"src": requisiteLink, "poster": requisiteLink},
+	"audio":  {"src": requisiteLink},
+	"track":  {"src": requisiteLink},
+	"embed":  {"src": requisiteLink},
+	"object": {"data": requisiteLink},
+	"image":  {"href": requisiteLink, "xlink:href": requisiteLink}, // SVG
+	"use":    {"href": requisiteLink, "xlink:href": requisiteLink}, // SVG
+	"script": {"src": requisiteLink},
+	"input":  {"src": requisiteLink},
+	"body":   {"background": requisiteLink},
+	"table":  {"background": requisiteLink},
+	"td":     {"background": requisiteLink},
+	"link":   {"href": pageLink, "imagesrcset": pageLink}, // upgraded to requisite by rel, see linkRelKind
+}
+
+// srcsetAttrs hold comma-separated lists of image candidates rather than a single URL
+var srcsetAttrs = map[string]bool{
+	"srcset":      true,
+	"imagesrcset": true,
+}
+
+// requisiteRels are <link rel> values whose target is needed to render the page
+var requisiteRels = map[string]bool{
+	"stylesheet":       true,
+	"icon":             true,
+	"shortcut":         true,
+	"apple-touch-icon": true,
+	"mask-icon":        true,
+	"preload":          true,
+	"prefetch":         true,
+	"manifest":         true,
+	"modulepreload":    true,
+}
+
+// metaImagePattern matches <meta content> values that point at images, such as og:image
+var metaImagePattern = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|ico|svg|webp)(\?.*)?$`)
+
+// extractLinks tokenizes an HTML document and returns every URL it refers to, in
+// document order. Comments, script bodies and text never produce links.
+func extractLinks(data []byte) []htmlLink {
+	var links []htmlLink
+	z := newHTMLTokenizer(data)
+	inStyle := false
+
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			break
+		}
+
+		switch tok.Type {
+		case startTagToken:
+			inStyle = tok.Tag == "style"
+			links = append(links, tagLinks(data, tok)...)
+		case endTagToken:
+			inStyle = false
+		case textToken:
+			if inStyle {
+				links = append(links, cssLinks(data, tok.Start, tok.End, "style", "")...)
+			}
+		}
+	}
+	return links
+}
+
+// textLink is a link together with the text a reader sees for it
+type textLink struct {
+	htmlLink
+	Text string
+}
+
+// extractLinkTexts returns the links of a document like extractLinks, each with its
+// text: the text inside an <a>, falling back to the alt of an image in it, or else
+// the alt, title or aria-label of the tag holding the link
+func extractLinkTexts(data []byte) []textLink {
+	var links []textLink
+	z := newHTMLTokenizer(data)
+	inStyle := false
+	anchor := -1 // index of the first link of the open <a>, if any
+	var anchorLabel string
+	var text, alt []string
+
+	closeAnchor := func() {
+		if anchor < 0 {
+			return
+		}
+		label := strings.Join(text, " ")
+		if label == "" {
+			label = strings.Join(alt, " ")
+		}
+		if label == "" {
+			label = anchorLabel
+		}
+		for i := anchor; i < len(links); i++ {
+			if links[i].Tag == "a" && links[i].Text == "" {
+				links[i].Text = label
+			}
+		}
+		anchor, text, alt = -1, nil, nil
+	}
+
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			break
+		}
+
+		switch tok.Type {
+		case startTagToken:
+			inStyle = tok.Tag == "style"
+			label := tagLabel(tok)
+			if tok.Tag == "a" {
+				closeAnchor()
+				anchor, anchorLabel = len(links), label
+			}
+			if anchor >= 0 && tok.Tag == "img" && label != "" {
+				alt = append(alt, label)
+			}
+			for _, link := range tagLinks(data, tok) {
+				textLink := textLink{htmlLink: link}
+				if tok.Tag != "a" {
+					textLink.Text = label
+				}
+				links = append(links, textLink)
+			}
+		case endTagToken:
+			inStyle = false
+			if tok.Tag == "a" {
+				closeAnchor()
+			}
+		case textToken:
+			if inStyle {
+				for _, link := range cssLinks(data, tok.Start, tok.End, "style", "") {
+					links = append(links, textLink{htmlLink: link})
+				}
+			} else if anchor >= 0 {
+				text = append(text, strings.Fields(html.UnescapeString(string(data[tok.Start:tok.End])))...)
+			}
+		}
+	}
+	closeAnchor()
+	return links
+}
+
+// tagLabel returns the alt, title or aria-label of a tag, the first one set
+func tagLabel(tok htmlToken) string {
+	for _, name := range []string{"alt", "title", "aria-label"} {
+		for _, attr := range tok.Attrs {
+			if attr.Name == name {
+				if label := strings.Join(strings.Fields(attr.Value), " "); label != "" {
+					return label
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// tagLinks returns the links held by the attributes of a start tag
+func tagLinks(data []byte, tok htmlToken) []htmlLink {
+	var links []htmlLink
+	attrs := linkAttrs[tok.Tag]
+
+	for _, attr := range tok.Attrs {
+		if attr.Name == "style" {
+			links = append(links, cssLinks(data, attr.ValueStart, attr.ValueEnd, tok.Tag, "style")...)
+			continue
+		}
+
+		if tok.Tag == "meta" && attr.Name == "content" && isRefresh(tok) {
+			if link, ok := refreshLink(data, attr); ok {
+				links = append(links, link)
+			}
+			continue
+		}
+
+		kind, ok := attrs[attr.Name]
+		if tok.Tag == "meta" && attr.Name == "content" && metaImagePattern.MatchString(strings.TrimSpace(attr.Value)) {
+			kind, ok = requisiteLink, true
+		}
+		if !ok {
+			continue
+		}
+		if tok.Tag == "link" {
+			kind = linkRelKind(tok)
+		}
+
+		if srcsetAttrs[attr.Name] {
+			for _, link := range srcsetLinks(data, tok.Tag, attr) {
+				link.Kind = kind
+				links = append(links, link)
+			}
+			continue
+		}
+
+		link := attrLink(data, tok.Tag, attr)
+		if link.URL == "" {
+			continue
+		}
+		link.Kind = kind
+		links = append(links, link)
+	}
+	return links
+}
+
+// attrLink builds a link from an attribute, trimming surrounding whitespace from
+// both the value and its offsets
+func attrLink(data []byte, tag string, attr htmlAttr) htmlLink {
+	link := htmlLink{
+		Tag:   tag,
+		Attr:  attr.Name,
+		URL:   strings.TrimSpace(attr.Value),
+		Start: attr.ValueStart,
+		End:   attr.ValueEnd,
+		Quote: attr.Quote,
+	}
+	for link.Start < link.End && isSpace(data[link.Start]) {
+		link.Start++
+	}
+	for link.End > link.Start && isSpace(data[link.End-1]) {
+		link.End--
+	}
+	return link
+}
+
+// isRefresh reports whether a <meta> tag is an http-equiv="refresh" directive
+func isRefresh(tok htmlToken) bool {
+	for _, attr := range tok.Attrs {
+		if attr.Name == "http-equiv" && strings.EqualFold(strings.TrimSpace(attr.Value), "refresh") {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshLink returns the target of a meta refresh, whose content looks like
+// "5; url='/next.html'". A refresh without a URL reloads the page and has no link.
+func refreshLink(data []byte, attr htmlAttr) (htmlLink, bool) {
+	value := data[attr.ValueStart:attr.ValueEnd]
+	skip := func(i int) int {
+		for i < len(value) && isSpace(value[i]) {
+			i++
+		}
+		return i
+	}
+
+	i := skip(0)
+	for i < len(value) && (value[i] >= '0' && value[i] <= '9' || value[i] == '.') {
+		i++
+	}
+	i = skip(i)
+	if i < len(value) && (value[i] == ';' || value[i] == ',') {
+		i = skip(i + 1)
+	}
+	if hasPrefixFold(value[i:], "url") {
+		if j := skip(i + len("url")); j < len(value) && value[j] == '=' {
+			i = skip(j + 1)
+		}
+	}
+
+	end := len(value)
+	if i < end && (value[i] == '"' || value[i] == '\'') {
+		quote := value[i]
+		i++
+		if q := bytes.IndexByte(value[i:], quote); q >= 0 {
+			end = i + q
+		}
+	}
+	for end > i && isSpace(value[end-1]) {
+		end--
+	}
+	if end <= i {
+		return htmlLink{}, false
+	}
+
+	return htmlLink{
+		Tag:   "meta",
+		Attr:  attr.Name,
+		URL:   html.UnescapeString(string(value[i:end])),
+		Kind:  pageLink,
+		Start: attr.ValueStart + i,
+		End:   attr.ValueStart + end,
+		Quote: attr.Quote,
+	}, true
+}
+
+// baseLink finds the <base href> of a document. Only the first one counts.
+func baseLink(data []byte) (htmlLink, bool) {
+	z := newHTMLTokenizer(data)
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			return htmlLink{}, false
+		}
+		if tok.Type != startTagToken || tok.Tag != "base" {
+			continue
+		}
+		for _, attr := range tok.Attrs {
+			if attr.Name == "href" {
+				return attrLink(data, tok.Tag, attr), true
+			}
+		}
+	}
+}
+
+// srcsetLinks returns one link per image candidate of a srcset value, following the
+// HTML parsing rules: a candidate is a URL, optionally followed by descriptors, and
+// a URL ending in a comma has none.
+func srcsetLinks(data []byte, tag string, attr htmlAttr) []htmlLink {
+	var links []htmlLink
+	value := data[attr.ValueStart:attr.ValueEnd]
+	i := 0
+
+	for {
+		for i < len(value) && (isSpace(value[i]) || value[i] == ',') {
+			i++
+		}
+		if i >= len(value) {
+			return links
+		}
+
+		start := i
+		for i < len(value) && !isSpace(value[i]) {
+			i++
+		}
+		end := i
+		if value[end-1] == ',' {
+			for end > start && value[end-1] == ',' {
+				end--
+			}
+		} else {
+			// Skip the descriptors, whose parentheses may hold commas
+			depth := 0
+			for ; i < len(value); i++ {
+				if value[i] == '(' {
+					depth++
+				} else if value[i] == ')' && depth > 0 {
+					depth--
+				} else if value[i] == ',' && depth == 0 {
+					break
+				}
+			}
+		}
+		if end == start {
+			continue
+		}
+
+		links = append(links, htmlLink{
+			Tag:   tag,
+			Attr:  attr.Name,
+			URL:   html.UnescapeString(string(value[start:end])),
+			Start: attr.ValueStart + start,
+			End:   attr.ValueStart + end,
+			Quote: attr.Quote,
+		})
+	}
+}
+
+// linkRelKind decides whether a <link> points at a requisite based on its rel
+func linkRelKind(tok htmlToken) linkKind {
+	for _, attr := range tok.Attrs {
+		if attr.Name != "rel" {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.ToLower(attr.Value)) {
+			if requisiteRels[rel] {
+				return requisiteLink
+			}
+		}
+	}
+	return pageLink
+}
+
+// cssLinks finds url() and @import references in data[start:end], which holds CSS
+func cssLinks(data []byte, start, end int, tag, attr string) []htmlLink {
+	var links []htmlLink
+	for _, ref := range scanCSS(data[start:end]) {
+		value := ref.URL
+		// Only attribute values carry character references
+		if attr != "" {
+			value = html.UnescapeString(value)
+		}
+		links = append(links, htmlLink{
+			Tag:   tag,
+			Attr:  attr,
+			URL:   value,
+			Kind:  requisiteLink,
+			Start: start + ref.Start,
+			End:   start + ref.End,
+		})
+	}
+	return links
+}
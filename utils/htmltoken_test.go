@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"html"
+	"testing"
+)
+
+func TestHTMLTokenizer(t *testing.T) {
+	doc := `<!DOCTYPE html><html><!-- <a href="x"> --><body class=main><p>Hi &amp; bye</p></body></html>`
+	z := newHTMLTokenizer([]byte(doc))
+
+	var types []htmlTokenType
+	var tags []string
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			break
+		}
+		types = append(types, tok.Type)
+		if tok.Type == startTagToken {
+			tags = append(tags, tok.Tag)
+		}
+	}
+
+	expectedTags := []string{"html", "body", "p"}
+	if len(tags) != len(expectedTags) {
+		t.Fatalf("start tags = %v; want %v", tags, expectedTags)
+	}
+	for i := range tags {
+		if tags[i] != expectedTags[i] {
+			t.Errorf("start tag %d = %q; want %q", i, tags[i], expectedTags[i])
+		}
+	}
+	if types[0] != doctypeToken || types[2] != commentToken {
+		t.Errorf("token types = %v; want a doctype then a comment after <html>", types)
+	}
+}
+
+func TestHTMLTokenizerAttributes(t *testing.T) {
+	doc := `<IMG SRC=logo.png alt='A "quoted" value' data-x = "a&amp;b" hidden>`
+	z := newHTMLTokenizer([]byte(doc))
+
+	tok, ok := z.Next()
+	if !ok || tok.Type != startTagToken || tok.Tag != "img" {
+		t.Fatalf("expected an img start tag, got %+v", tok)
+	}
+
+	expected := []htmlAttr{
+		{Name: "src", Value: "logo.png", Quote: 0},
+		{Name: "alt", Value: `A "quoted" value`, Quote: '\''},
+		{Name: "data-x", Value: "a&b", Quote: '"'},
+		{Name: "hidden", Value: "", Quote: 0},
+	}
+	if len(tok.Attrs) != len(expected) {
+		t.Fatalf("attributes = %+v; want %d of them", tok.Attrs, len(expected))
+	}
+	for i, attr := range tok.Attrs {
+		if attr.Name != expected[i].Name || attr.Value != expected[i].Value || attr.Quote != expected[i].Quote {
+			t.Errorf("attribute %d = %+v; want %+v", i, attr, expected[i])
+		}
+	}
+
+	// Offsets point at the raw value, without quotes
+	if raw := doc[tok.Attrs[0].ValueStart:tok.Attrs[0].ValueEnd]; raw != "logo.png" {
+		t.Errorf("raw src = %q; want %q", raw, "logo.png")
+	}
+	if raw := doc[tok.Attrs[2].ValueStart:tok.Attrs[2].ValueEnd]; raw != "a&amp;b" {
+		t.Errorf("raw data-x = %q; want %q", raw, "a&amp;b")
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	doc := `<html><head>
+<link rel="stylesheet" href="/css/site.css">
+<link rel="canonical" href="https://example.com/">
+<style>body { background: url('/img/bg.png'); }</style>
+<script src=/js/app.js></script>
+<script>var s = '<img src="/not-a-link.png">';</script>
+</head><body>
+<!-- <a href="/commented.html">old</a> -->
+<a href="/about.html">About</a>
+<p>Plain text mentioning src="/text.png" is not a link</p>
+<div style="background-image: url(/img/hero.jpg)"></div>
+<img src="/img/logo.png">
+</body></html>`
+
+	links := extractLinks([]byte(doc))
+
+	expected := []struct {
+		url  string
+		tag  string
+		kind linkKind
+	}{
+		{"/css/site.css", "link", requisiteLink},
+		{"https://example.com/", "link", pageLink},
+		{"/img/bg.png", "style", requisiteLink},
+		{"/js/app.js", "script", requisiteLink},
+		{"/about.html", "a", pageLink},
+		{"/img/hero.jpg", "div", requisiteLink},
+		{"/img/logo.png", "img", requisiteLink},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("found %d links %+v; want %d", len(links), links, len(expected))
+	}
+	for i, link := range links {
+		if link.URL != expected[i].url || link.Tag != expected[i].tag || link.Kind != expected[i].kind {
+			t.Errorf("link %d = %+v; want %+v", i, link, expected[i])
+		}
+		if raw := doc[link.Start:link.End]; raw != link.URL {
+			t.Errorf("link %d offsets cover %q; want %q", i, raw, link.URL)
+		}
+	}
+}
+
+func TestExtractLinksMediaAndSrcset(t *testing.T) {
+	doc := `<picture>
+<source type="image/webp" srcset="/img/a.webp 1x, /img/a,2x.webp 2x">
+<img src="/img/a.jpg" srcset="/img/s.jpg 480w,/img/m.jpg 800w, /img/l.jpg?w=1200&amp;q=80 1200w" sizes="(max-width: 600px) 480px, 800px">
+</picture>
+<link rel="preload" as="image" imagesrcset="/img/p1.png 1x, /img/p2.png 2x">
+<video poster="/media/poster.jpg"><source src="/media/clip.mp4"><track src="/media/subs.vtt"></video>
+<audio src="/media/song.ogg"></audio>
+<object data="/media/chart.svg"></object><embed src="/media/anim.swf">
+<svg><image href="/img/vector.png"/><image xlink:href="/img/legacy.png"/><use xlink:href="/img/sprite.svg#icon"/></svg>
+<div style="background: url('/img/bg.png') /* url(/img/commented.png) */"></div>`
+
+	links := extractLinks([]byte(doc))
+
+	expected := []string{
+		"/img/a.webp", "/img/a,2x.webp",
+		"/img/a.jpg", "/img/s.jpg", "/img/m.jpg", "/img/l.jpg?w=1200&q=80",
+		"/img/p1.png", "/img/p2.png",
+		"/media/poster.jpg", "/media/clip.mp4", "/media/subs.vtt",
+		"/media/song.ogg",
+		"/media/chart.svg", "/media/anim.swf",
+		"/img/vector.png", "/img/legacy.png", "/img/sprite.svg#icon",
+		"/img/bg.png",
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("found %d links %+v; want %d", len(links), links, len(expected))
+	}
+	for i, link := range links {
+		if link.URL != expected[i] {
+			t.Errorf("link %d = %q; want %q", i, link.URL, expected[i])
+		}
+		if link.Kind != requisiteLink {
+			t.Errorf("link %d (%s) should be a requisite", i, link.URL)
+		}
+	}
+}
+
+func TestSrcsetLinksOffsets(t *testing.T) {
+	doc := `<img srcset=" a.png , b.png 2x,c.png,, d.png (x, y) 3x">`
+	links := extractLinks([]byte(doc))
+
+	expected := []string{"a.png", "b.png", "c.png", "d.png"}
+	if len(links) != len(expected) {
+		t.Fatalf("found %d links %+v; want %d", len(links), links, len(expected))
+	}
+	for i, link := range links {
+		if raw := doc[link.Start:link.End]; raw != expected[i] || link.URL != expected[i] {
+			t.Errorf("link %d = %q at %q; want %q", i, link.URL, raw, expected[i])
+		}
+	}
+}
+
+func TestRefreshLink(t *testing.T) {
+	tests := []struct {
+		doc      string
+		expected string
+	}{
+		{`<meta http-equiv="refresh" content="0; url=/new/">`, "/new/"},
+		{`<meta content="5;URL='next.html?a=1&amp;b=2'" http-equiv="Refresh">`, "next.html?a=1&b=2"},
+		{`<meta http-equiv="refresh" content="3, other.html ">`, "other.html"},
+		{`<meta http-equiv="refresh" content="30">`, ""},
+	}
+	for _, tt := range tests {
+		links := extractLinks([]byte(tt.doc))
+		if tt.expected == "" {
+			if len(links) != 0 {
+				t.Errorf("extractLinks(%q) = %+v; want no links", tt.doc, links)
+			}
+			continue
+		}
+		if len(links) != 1 || links[0].URL != tt.expected {
+			t.Errorf("extractLinks(%q) = %+v; want %q", tt.doc, links, tt.expected)
+			continue
+		}
+		if raw := html.UnescapeString(tt.doc[links[0].Start:links[0].End]); raw != tt.expected {
+			t.Errorf("offsets of %q cover %q", tt.doc, raw)
+		}
+	}
+}
+
+func TestDocumentBase(t *testing.T) {
+	tests := []struct {
+		doc      string
+		expected string
+	}{
+		{`<head><base href="/assets/"><base href="/ignored/"></head>`, "http://example.com/assets/"},
+		{`<head><base target="_blank"><base href="https://cdn.example.com/v2/"></head>`, "https://cdn.example.com/v2/"},
+		{`<head><base target="_blank"></head>`, "http://example.com/docs/page.html"},
+		{`<p>no base</p>`, "http://example.com/docs/page.html"},
+	}
+	for _, tt := range tests {
+		if got := documentBase([]byte(tt.doc), "http://example.com/docs/page.html"); got != tt.expected {
+			t.Errorf("documentBase(%q) = %q; want %q", tt.doc, got, tt.expected)
+		}
+	}
+}
+
+func TestExtractLinkTexts(t *testing.T) {
+	doc := `<p><a href="/a.html">About
+  <b>us</b> &amp; more</a>
+<a href="/home"><img src="/logo.png" alt="Home page"></a>
+<a href="/x" title="Close"></a>
+<img src="/chart.png" title="Sales chart">
+<link rel="stylesheet" href="/s.css"></p>`
+	expected := map[string]string{
+		"/a.html":    "About us & more",
+		"/home":      "Home page",
+		"/logo.png":  "Home page",
+		"/x":         "Close",
+		"/chart.png": "Sales chart",
+		"/s.css":     "",
+	}
+	links := extractLinkTexts([]byte(doc))
+	if len(links) != len(expected) {
+		t.Fatalf("got %d links; want %d: %+v", len(links), len(expected), links)
+	}
+	for _, link := range links {
+		if want := expected[link.URL]; link.Text != want {
+			t.Errorf("text of %s = %q; want %q", link.URL, link.Text, want)
+		}
+	}
+}
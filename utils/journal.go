@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// journalName is the file in the mirror root that records the progress of a crawl
+// until it finishes, so --continue can resume an interrupted one
+const journalName = ".wget-journal.json"
+
+// journalInterval is how often the journal is rewritten while crawling
+var journalInterval = 5 * time.Second
+
+// crawlJournal is the progress of a crawl between two pages
+type crawlJournal struct {
+	StartURL string                  `json:"start_url"`
+	Pages    int                     `json:"pages"`
+	Frontier []journalItem           `json:"frontier"`
+	Visited  []string                `json:"visited"`
+	Done     []journalItem           `json:"done,omitempty"` // pages already processed
+	Files    map[string]*journalFile `json:"files"`          // normalised URL -> status
+	LastMod  map[string]time.Time    `json:"lastmod,omitempty"`
+}
+
+// journalItem is a page still waiting in the frontier, or one already processed
+type journalItem struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+}
+
+// journalFile is the status of one URL the crawl tried to download
+type journalFile struct {
+	Status    string      `json:"status"` // "done" or "failed"
+	Error     string      `json:"error,omitempty"`
+	Path      string      `json:"path,omitempty"`
+	Kind      contentKind `json:"kind,omitempty"`
+	Rejected  bool        `json:"rejected,omitempty"`
+	Scanned   bool        `json:"scanned,omitempty"` // a stylesheet whose references were downloaded
+	Unchanged bool        `json:"unchanged,omitempty"`
+	State     *urlState   `json:"state,omitempty"`
+}
+
+// saveJournal records the progress of the crawl. It is only called between pages,
+// when no download is running.
+func (m *mirrorer) saveJournal(startURL string, pages int, frontier, done []crawlItem, visited map[string]bool) {
+	journal := &crawlJournal{
+		StartURL: startURL,
+		Pages:    pages,
+		Files:    make(map[string]*journalFile),
+		LastMod:  m.lastMod,
+	}
+	for _, item := range frontier {
+		journal.Frontier = append(journal.Frontier, journalItem{URL: item.url, Depth: item.depth})
+	}
+	for _, item := range done {
+		journal.Done = append(journal.Done, journalItem{URL: item.url, Depth: item.depth})
+	}
+	for key := range visited {
+		journal.Visited = append(journal.Visited, key)
+	}
+	sort.Strings(journal.Visited)
+
+	m.mu.Lock()
+	for key, entry := range m.files {
+		file := &journalFile{Status: "done", Path: entry.path}
+		if entry.err != nil {
+			file = &journalFile{Status: "failed", Error: entry.err.Error()}
+		} else {
+			file.Rejected = m.rejected[key]
+			file.Scanned = m.cssFiles[key]
+			file.Unchanged = m.unchanged[key]
+			file.State = m.state[key]
+			if m.htmlPages[key] {
+				file.Kind = htmlContent
+			} else if m.cssTyped[key] {
+				file.Kind = cssContent
+			}
+		}
+		journal.Files[key] = file
+	}
+	data, err := json.Marshal(journal)
+	m.mu.Unlock()
+
+	if err == nil {
+		err = m.writeFile(journalName, data)
+	}
+	if err != nil {
+		fmt.Printf("Error saving crawl journal: %v\n", err)
+	}
+}
+
+// loadJournal reads the journal an interrupted crawl of startURL left behind. It
+// returns nil when there is none, or when it belongs to another start URL.
+func (m *mirrorer) loadJournal(startURL string) *crawlJournal {
+	data, err := os.ReadFile(m.fullPath(journalName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error reading crawl journal: %v\n", err)
+		}
+		return nil
+	}
+	var journal crawlJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		fmt.Printf("Ignoring invalid crawl journal %s: %v\n", m.fullPath(journalName), err)
+		return nil
+	}
+	if journal.StartURL != startURL {
+		fmt.Printf("Ignoring crawl journal of %s\n", journal.StartURL)
+		return nil
+	}
+	return &journal
+}
+
+// resume restores the crawl recorded in journal. URLs downloaded before the
+// interruption count as done and are not fetched again. A download that failed is
+// retried by processing again the pages that led to it, directly or through a
+// stylesheet. It returns the frontier, the visited set and the pages done.
+func (m *mirrorer) resume(journal *crawlJournal) ([]crawlItem, map[string]bool, []crawlItem) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restorable := func(file *journalFile) bool {
+		if file.Status != "done" || insideRoot(file.Path) != nil {
+			return false
+		}
+		_, err := os.Stat(m.fullPath(file.Path))
+		return err == nil // else removed since, download it again
+	}
+	retry := make(map[string]bool)
+	for key, file := range journal.Files {
+		if !restorable(file) {
+			retry[key] = true
+		}
+	}
+	// Whatever links to a URL to retry is processed again too
+	for changed := len(retry) > 0; changed; {
+		changed = false
+		for key, file := range journal.Files {
+			if retry[key] || file.State == nil {
+				continue
+			}
+			for _, link := range file.State.Links {
+				if retry[normalizeURL(link.URL)] {
+					retry[key] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	restored := 0
+	for key, file := range journal.Files {
+		if !restorable(file) {
+			continue
+		}
+		entry := &fileEntry{path: file.Path}
+		entry.once.Do(func() {})
+		m.files[key] = entry
+		m.paths[file.Path] = key
+		switch file.Kind {
+		case htmlContent:
+			m.htmlPages[key] = true
+		case cssContent:
+			m.cssTyped[key] = true
+		}
+		// These sets are read by their keys, so only true values are stored
+		if file.Rejected {
+			m.rejected[key] = true
+		}
+		if file.Scanned && !retry[key] {
+			m.cssFiles[key] = true
+		}
+		if file.Unchanged {
+			m.unchanged[key] = true
+		}
+		if file.State != nil {
+			m.state[key] = file.State
+		}
+		restored++
+	}
+	for key, lastMod := range journal.LastMod {
+		m.lastMod[key] = lastMod
+	}
+
+	visited := make(map[string]bool)
+	for _, key := range journal.Visited {
+		visited[key] = true
+	}
+	var frontier, done []crawlItem
+	for _, item := range journal.Done {
+		if retry[normalizeURL(item.URL)] {
+			frontier = append(frontier, crawlItem{url: item.URL, depth: item.Depth})
+		} else {
+			done = append(done, crawlItem{url: item.URL, depth: item.Depth})
+		}
+	}
+	again := len(frontier)
+	for _, item := range journal.Frontier {
+		frontier = append(frontier, crawlItem{url: item.URL, depth: item.Depth})
+	}
+	fmt.Printf("Resuming crawl: %d files already downloaded, %d pages waiting, %d of them to retry failed downloads\n", restored, len(frontier), again)
+	return frontier, visited, done
+}
+
+// stopOnInterrupt makes Ctrl-C or a plain kill (SIGTERM) stop the crawl between two
+// pages, as a limit would, so the journal is saved with everything done until then and
+// no temporary file is left behind. A second signal quits at once. The returned
+// function stops listening.
+func (m *mirrorer) stopOnInterrupt() func() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupts:
+			signal.Stop(interrupts)
+			fmt.Println("\nInterrupted, finishing the downloads in progress. Interrupt again to quit at once")
+			m.budget.interrupt()
+		case <-done:
+			signal.Stop(interrupts)
+		}
+	}()
+	return func() { close(done) }
+}
+
+// removeJournal deletes the journal once the crawl has finished
+func (m *mirrorer) removeJournal() {
+	if err := os.Remove(m.fullPath(journalName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Error removing crawl journal: %v\n", err)
+	}
+}
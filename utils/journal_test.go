@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMirrorWebsiteContinue(t *testing.T) {
+	pages := map[string]string{
+		"/":       `<html><body><a href="/a.html">A</a> <a href="/b.html">B</a></body></html>`,
+		"/a.html": `<html><body>A</body></html>`,
+		"/b.html": `<html><body><a href="/c.html">C</a></body></html>`,
+		"/c.html": `<html><body>C</body></html>`,
+	}
+	var mu sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+
+	// The journal of a crawl interrupted after the start page: a.html and b.html are
+	// saved, b.html still has to be crawled
+	for name, page := range map[string]string{"index.html": "/", "a.html": "/a.html"} {
+		os.MkdirAll(filepath.Join(dir, host.Host), 0755)
+		if err := os.WriteFile(filepath.Join(dir, host.Host, name), []byte(pages[page]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal := crawlJournal{
+		StartURL: server.URL + "/",
+		Pages:    1,
+		Frontier: []journalItem{{URL: server.URL + "/a.html", Depth: 1}, {URL: server.URL + "/b.html", Depth: 1}},
+		Visited:  []string{server.URL + "/", server.URL + "/a.html", server.URL + "/b.html"},
+		Files: map[string]*journalFile{
+			server.URL + "/":       {Status: "done", Path: host.Host + "/index.html", Kind: htmlContent},
+			server.URL + "/a.html": {Status: "done", Path: host.Host + "/a.html", Kind: htmlContent},
+			server.URL + "/b.html": {Status: "failed", Error: "connection reset"},
+		},
+	}
+	data, _ := json.Marshal(journal)
+	if err := os.WriteFile(filepath.Join(dir, journalName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := MirrorOptions{Recursive: true, ConvertLinks: true, Continue: true}
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	mu.Lock()
+	got := strings.Join(fetched, ",")
+	mu.Unlock()
+	if got != "/b.html,/c.html" {
+		t.Errorf("only the failed and the unvisited pages should be fetched, got %s", got)
+	}
+	index, _ := os.ReadFile(filepath.Join(dir, host.Host, "index.html"))
+	if !strings.Contains(string(index), `href="b.html"`) || !strings.Contains(string(index), `href="a.html"`) {
+		t.Errorf("pages saved before the interruption were not converted: %s", index)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal should be removed once the crawl finishes")
+	}
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	m := &mirrorer{
+		root:      dir,
+		files:     make(map[string]*fileEntry),
+		htmlPages: make(map[string]bool),
+		rejected:  make(map[string]bool),
+		lastMod:   make(map[string]time.Time),
+		cssFiles:  make(map[string]bool),
+		cssTyped:  make(map[string]bool),
+		paths:     make(map[string]string),
+		state:     make(map[string]*urlState),
+		unchanged: make(map[string]bool),
+	}
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte("body{}"), 0644)
+	m.files["http://example.com/style.css"] = &fileEntry{path: "style.css"}
+	m.cssTyped["http://example.com/style.css"] = true
+	m.cssFiles["http://example.com/style.css"] = true
+	m.files["http://example.com/gone.png"] = &fileEntry{err: errors.New("404 Not Found")}
+
+	frontier := []crawlItem{{url: "http://example.com/next.html", depth: 2}}
+	visited := map[string]bool{"http://example.com/": true, "http://example.com/next.html": true}
+	m.saveJournal("http://example.com/", 3, frontier, nil, visited)
+
+	if m.loadJournal("http://other.example/") != nil {
+		t.Errorf("a journal of another start URL should be ignored")
+	}
+	journal := m.loadJournal("http://example.com/")
+	if journal == nil || journal.Pages != 3 {
+		t.Fatalf("journal was not saved: %+v", journal)
+	}
+
+	fresh := &mirrorer{
+		root:      dir,
+		files:     make(map[string]*fileEntry),
+		htmlPages: make(map[string]bool),
+		rejected:  make(map[string]bool),
+		lastMod:   make(map[string]time.Time),
+		cssFiles:  make(map[string]bool),
+		cssTyped:  make(map[string]bool),
+		paths:     make(map[string]string),
+		state:     make(map[string]*urlState),
+		unchanged: make(map[string]bool),
+	}
+	gotFrontier, gotVisited, _ := fresh.resume(journal)
+	if len(gotFrontier) != 1 || gotFrontier[0] != frontier[0] || len(gotVisited) != 2 {
+		t.Errorf("frontier or visited set not restored: %v %v", gotFrontier, gotVisited)
+	}
+	if path, err := fresh.saveURL("http://example.com/style.css", false); err != nil || path != "style.css" {
+		t.Errorf("a finished download should be reused, got %q, %v", path, err)
+	}
+	if !fresh.cssFiles["http://example.com/style.css"] || !fresh.cssTyped["http://example.com/style.css"] {
+		t.Errorf("stylesheet status not restored")
+	}
+	if _, ok := fresh.files["http://example.com/gone.png"]; ok {
+		t.Errorf("a failed download should be retried")
+	}
+}
+
+func TestMirrorWebsiteContinueRetriesFailed(t *testing.T) {
+	site := &mutableSite{
+		pages: map[string]string{
+			"/":       `<html><body><a href="/a.html">A</a> <a href="/b.html">B</a></body></html>`,
+			"/a.html": `<html><head><link rel="stylesheet" href="/a.css"></head><body>A</body></html>`,
+			"/a.css":  `body { background: url(/bg.png) }`,
+			"/b.html": `<html><body><a href="/c.html">C</a></body></html>`,
+			"/c.html": `<html><body>C</body></html>`,
+			"/bg.png": "png",
+		},
+		broken: map[string]bool{"/bg.png": true},
+	}
+	server := httptest.NewServer(site)
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+
+	// Stopped after two pages, with the image of a stylesheet of the second failed
+	opts := MirrorOptions{Recursive: true}
+	opts.MaxPages = 2
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("first mirror failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, host.Host, "bg.png")); !os.IsNotExist(err) {
+		t.Fatalf("broken image should not be saved")
+	}
+
+	site.mu.Lock()
+	delete(site.broken, "/bg.png")
+	site.mu.Unlock()
+	opts.MaxPages = 0
+	opts.Continue = true
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("continued mirror failed: %v", err)
+	}
+	for _, want := range []string{"bg.png", "c.html"} {
+		if _, err := os.Stat(filepath.Join(dir, host.Host, want)); err != nil {
+			t.Errorf("%s should be downloaded by the continued mirror: %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits caps how much an -i batch or a mirror downloads. Once Quota, MaxPages or
+// MaxTime is reached no new download starts, and the ones running are finished.
+// MaxFileSize applies to each file on its own. Zero means no limit.
+type Limits struct {
+	Quota       int64 // total bytes
+	MaxPages    int   // pages of a mirror, files of an -i batch
+	MaxTime     time.Duration
+	MaxFileSize int64
+}
+
+// errFileTooLarge is the error of a download aborted by --max-filesize
+var errFileTooLarge = errors.New("file is larger than --max-filesize")
+
+// ParseSize reads a size such as 500, 400k, 500M or 2G as a number of bytes. An
+// empty value is 0, no limit.
+func ParseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	value := strings.ToLower(size)
+	var multiplier int64 = 1
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(value, "m"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(value, "g"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes such as 500k or 2M", size)
+	}
+	return n * multiplier, nil
+}
+
+// parseDuration reads a --max-time value, in seconds or as a duration such as 10m
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid --max-time %q, expected seconds or a duration such as 10m", value)
+	}
+	return d, nil
+}
+
+// downloadBudget tracks the Limits of one batch or mirror across its concurrent
+// downloads. A nil budget has no limits.
+type downloadBudget struct {
+	limits Limits
+	start  time.Time
+
+	mu     sync.Mutex
+	bytes  int64
+	pages  int
+	reason string // the limit that stopped the run, "" while there is room left
+}
+
+func newDownloadBudget(limits Limits) *downloadBudget {
+	return &downloadBudget{limits: limits, start: time.Now()}
+}
+
+// exhausted returns the limit that stops new downloads, --quota or --max-time, or ""
+// while there is room left. --max-pages is left to take.
+func (b *downloadBudget) exhausted() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.checkLocked()
+}
+
+func (b *downloadBudget) checkLocked() string {
+	if b.reason == "" {
+		switch {
+		case b.limits.Quota > 0 && b.bytes >= b.limits.Quota:
+			b.reason = "--quota"
+		case b.limits.MaxTime > 0 && time.Since(b.start) >= b.limits.MaxTime:
+			b.reason = "--max-time"
+		}
+	}
+	return b.reason
+}
+
+// take claims one of the --max-pages for a new page or file. It reports false once
+// any limit has been reached, and the page should not be started.
+func (b *downloadBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.checkLocked() != "" {
+		return false
+	}
+	if b.limits.MaxPages > 0 && b.pages >= b.limits.MaxPages {
+		b.reason = "--max-pages"
+		return false
+	}
+	b.pages++
+	return true
+}
+
+// stopped returns the limit that stopped the run, "" if none did
+func (b *downloadBudget) stopped() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reason
+}
+
+// interrupt stops the run as a limit would, for Ctrl-C or SIGTERM
+func (b *downloadBudget) interrupt() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reason == "" {
+		b.reason = "interrupted"
+	}
+}
+
+// summary describes the limit that stopped the run, "" if none did
+func (b *downloadBudget) summary() string {
+	switch b.stopped() {
+	case "--quota":
+		return fmt.Sprintf("--quota of %s reached (%s downloaded)", formatSize(b.limits.Quota), formatSize(b.downloaded()))
+	case "--max-pages":
+		return fmt.Sprintf("--max-pages of %d reached", b.limits.MaxPages)
+	case "--max-time":
+		return fmt.Sprintf("--max-time of %s reached", b.limits.MaxTime)
+	case "interrupted":
+		return "interrupted"
+	}
+	return ""
+}
+
+// downloaded returns the bytes read so far
+func (b *downloadBudget) downloaded() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bytes
+}
+
+// checkSize refuses a download whose Content-Length is over --max-filesize
+func (b *downloadBudget) checkSize(contentLength int64) error {
+	if b == nil || b.limits.MaxFileSize <= 0 || contentLength <= b.limits.MaxFileSize {
+		return nil
+	}
+	return fmt.Errorf("%w (%s > %s)", errFileTooLarge, formatSize(contentLength), formatSize(b.limits.MaxFileSize))
+}
+
+// reader counts what r reads against the quota and fails once more than
+// --max-filesize has been read, for servers that send no Content-Length
+func (b *downloadBudget) reader(r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &budgetReader{reader: r, budget: b}
+}
+
+type budgetReader struct {
+	reader io.Reader
+	budget *downloadBudget
+	read   int64
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	r.budget.mu.Lock()
+	r.budget.bytes += int64(n)
+	r.budget.mu.Unlock()
+	if limit := r.budget.limits.MaxFileSize; limit > 0 && r.read > limit {
+		return n, fmt.Errorf("%w (%s)", errFileTooLarge, formatSize(limit))
+	}
+	return n, err
+}
+
+// formatSize writes a number of bytes the way the size flags take them
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1024*1024*1024:
+		return fmt.Sprintf("%.2fG", float64(bytes)/(1024*1024*1024))
+	case bytes >= 1024*1024:
+		return fmt.Sprintf("%.2fM", float64(bytes)/(1024*1024))
+	case bytes >= 1024:
+		return fmt.Sprintf("%.2fk", float64(bytes)/1024)
+	}
+	return fmt.Sprintf("%d bytes", bytes)
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 0},
+		{"500", 500},
+		{"400k", 400 * 1024},
+		{"500M", 500 * 1024 * 1024},
+		{"2g", 2 * 1024 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if err != nil {
+			t.Errorf("ParseSize(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d; want %d", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"M", "-1k", "ten"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("ParseSize(%q) should fail", value)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"90", 90 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{"10m", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.value)
+		if err != nil {
+			t.Errorf("parseDuration(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDuration(%q) = %v; want %v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"-5", "soon"} {
+		if _, err := parseDuration(value); err == nil {
+			t.Errorf("parseDuration(%q) should fail", value)
+		}
+	}
+}
+
+func TestDownloadFilesConcurrentlyLimits(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/streamed" {
+			// Flushing first leaves the response without a Content-Length
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body + body))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		limits Limits
+		want   int // files downloaded, one job at a time
+	}{
+		{"max pages", Limits{MaxPages: 2}, 2},
+		{"quota", Limits{Quota: 1500}, 2},
+		{"max time", Limits{MaxTime: time.Nanosecond}, 0},
+		{"no limits", Limits{}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			urls := []string{server.URL + "/1", server.URL + "/2", server.URL + "/3", server.URL + "/4"}
+			if err := DownloadFilesConcurrently(urls, "file", true, 0, outputDir, 1, tt.limits); err != nil {
+				t.Fatalf("a batch stopped by a limit should not fail: %v", err)
+			}
+			entries, _ := os.ReadDir(outputDir)
+			if len(entries) != tt.want {
+				t.Errorf("downloaded %d files; want %d", len(entries), tt.want)
+			}
+		})
+	}
+
+	// Oversize files are aborted whether or not the server announces their size
+	outputDir := t.TempDir()
+	urls := []string{server.URL + "/sized", server.URL + "/streamed"}
+	if err := DownloadFilesConcurrently(urls, "big", true, 0, outputDir, 2, Limits{MaxFileSize: 1500}); err == nil {
+		t.Errorf("expected an error for the file over --max-filesize")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "big_0")); err != nil {
+		t.Errorf("file under --max-filesize should be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "big_1")); !os.IsNotExist(err) {
+		t.Errorf("file over --max-filesize should be removed")
+	}
+
+	// A batch a limit stopped would have missing parts
+	urls = []string{server.URL + "/1", server.URL + "/2", server.URL + "/3"}
+	if err := DownloadFilesConcatenated(urls, "joined.bin", true, 0, outputDir, 1, Limits{MaxPages: 2}); err == nil {
+		t.Errorf("expected an error when a limit stops a --concat batch")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "joined.bin")); !os.IsNotExist(err) {
+		t.Errorf("output file should not exist after a batch stopped by a limit")
+	}
+}
+
+func TestMirrorWebsiteLimits(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":        `<html><body><a href="/a.html">A</a> <a href="/big.bin">Big</a></body></html>`,
+		"/a.html":  `<html><body><a href="/b.html">B</a></body></html>`,
+		"/b.html":  `<html><body><a href="/c.html">C</a></body></html>`,
+		"/c.html":  `<html><body>C</body></html>`,
+		"/big.bin": strings.Repeat("x", 2000),
+	})
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+	hostDir := filepath.Join(dir, host.Host)
+
+	opts := MirrorOptions{Recursive: true, DeleteAfterSync: true}
+	opts.MaxPages = 2
+	opts.MaxFileSize = 1000
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("a mirror stopped by a limit should not fail: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(hostDir, "big.bin")); !os.IsNotExist(err) {
+		t.Errorf("file over --max-filesize should not be saved")
+	}
+	// Pages downloaded as links of another page count as well
+	for _, name := range []string{"b.html", "c.html"} {
+		if _, err := os.Stat(filepath.Join(hostDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s is past --max-pages and should not be saved", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalName)); err != nil {
+		t.Fatalf("a stopped crawl should keep its journal: %v", err)
+	}
+
+	opts.Continue = true
+	opts.MaxPages = 0
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("continued mirror failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(hostDir, "c.html")); err != nil {
+		t.Errorf("continued mirror should crawl the rest: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalName)); !os.IsNotExist(err) {
+		t.Errorf("journal should be removed once the crawl finishes")
+	}
+
+	// No more HTML files are saved than --max-pages, however many pages the first links to
+	wide := newTestSite(map[string]string{
+		"/":       `<html><body><a href="/1.html">1</a> <a href="/2.html">2</a> <a href="/3.html">3</a> <a href="/4.html">4</a></body></html>`,
+		"/1.html": `<html><body>1</body></html>`,
+		"/2.html": `<html><body>2</body></html>`,
+		"/3.html": `<html><body>3</body></html>`,
+		"/4.html": `<html><body>4</body></html>`,
+	})
+	defer wide.Close()
+	wideHost, _ := url.Parse(wide.URL)
+	opts = MirrorOptions{Recursive: true}
+	opts.MaxPages = 1
+	if err := MirrorWebsite(wide.URL+"/", opts); err != nil {
+		t.Fatalf("a mirror stopped by --max-pages should not fail: %v", err)
+	}
+	if saved, _ := filepath.Glob(filepath.Join(dir, wideHost.Host, "*.html")); len(saved) != 1 {
+		t.Errorf("saved %d HTML files with --max-pages=1; want 1", len(saved))
+	}
+	os.Remove(filepath.Join(dir, journalName))
+
+	// Past the quota the rest of the page's images are not started
+	var images strings.Builder
+	pages := map[string]string{}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&images, `<img src="/img%d.png">`, i)
+		pages[fmt.Sprintf("/img%d.png", i)] = strings.Repeat("x", 10000)
+	}
+	pages["/gallery.html"] = `<html><body>` + images.String() + `</body></html>`
+	gallery := newTestSite(pages)
+	defer gallery.Close()
+	galleryHost, _ := url.Parse(gallery.URL)
+	opts = MirrorOptions{Recursive: true, MaxPerHost: 1}
+	opts.Quota = 20000
+	if err := MirrorWebsite(gallery.URL+"/gallery.html", opts); err != nil {
+		t.Fatalf("a mirror stopped by --quota should not fail: %v", err)
+	}
+	saved, _ := filepath.Glob(filepath.Join(dir, galleryHost.Host, "img*.png"))
+	if len(saved) == 0 || len(saved) > 3 {
+		t.Errorf("saved %d images with a quota of two; want the ones started before it was reached", len(saved))
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalName)); err != nil {
+		t.Errorf("a crawl stopped by --quota should keep its journal: %v", err)
+	}
+}
@@ -1,299 +1,710 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
+// MirrorOptions controls what a mirror downloads and how it is saved.
+type MirrorOptions struct {
+	Accept       []string // file name suffixes or globs to keep
+	Reject       []string // file name suffixes or globs to skip
+	AcceptRegex  string   // matched against the full URL
+	RejectRegex  string
+	Include      []string // directories to keep
+	Exclude      []string // directories to skip
+	ConvertLinks bool
+	RateLimit    int64 // bytes per second for the whole mirror, 0 for no limit
+	Wait         time.Duration
+	RandomWait   bool
+	MaxPerHost   int
+	Recursive    bool
+	Level        int // maximum recursion depth, 0 for no limit
+
+	// PageRequisites downloads everything needed to render each processed page,
+	// even past the depth limit. Without Recursive only the start page is processed.
+	PageRequisites bool
+
+	// SpanHosts follows links to any host. Domains limits that to hosts equal to or
+	// under the listed domains (and implies SpanHosts); ExcludeDomains always wins.
+	SpanHosts      bool
+	Domains        []string
+	ExcludeDomains []string
+	IgnoreRobots   bool // -e robots=off
+	Sitemap        bool // also crawl the pages listed in the site's sitemaps
+	Continue       bool // resume the crawl recorded in the journal of an interrupted run
+	// RestrictFileNames lists unix, windows, ascii or lowercase; empty means the
+	// rules of the running system
+	RestrictFileNames string
+	AdjustExtension   bool // add .html or .css to files served as such but named otherwise
+
+	DirectoryPrefix     string // folder the mirror is saved in, the working directory if empty
+	NoDirectories       bool   // -nd: save every file directly in the prefix folder
+	NoHostDirectories   bool   // -nH: no folder per host
+	CutDirs             int    // leading path components to drop
+	ProtocolDirectories bool   // put host folders in a folder per scheme
+
+	// DeleteAfterSync removes the files in the mirror a successful crawl did not
+	// produce. SyncDryRun only lists them, SyncMaxDelete ("N" or "N%") caps how many
+	// may go, 50% of the mirror by default.
+	DeleteAfterSync bool
+	SyncDryRun      bool
+	SyncMaxDelete   string
+
+	Spider bool // check links without saving anything and report the broken ones
+
+	// Limits stop the crawl once reached: no new download starts, and the ones
+	// running are finished
+	Limits
+}
+
+// DefaultLevel is the recursion depth used by -r when -l is not given.
+const DefaultLevel = 5
+
+// mirrorer carries the state shared by every download of one mirror run.
+type mirrorer struct {
+	opts      MirrorOptions
+	root      string // folder holding one directory per host, "" for the working directory
+	startHost string
+	limiter   *RateLimiter // shared by all concurrent downloads, nil when unlimited
+	sched     *hostScheduler
+	filter    *fileFilter
+	names     fileNameRules
+	budget    *downloadBudget
+
+	mu        sync.Mutex
+	files     map[string]*fileEntry   // normalised URL -> download result
+	htmlPages map[string]bool         // normalised URLs whose content is HTML
+	rejected  map[string]bool         // pages kept only until the crawl has read their links
+	robots    map[string]*robotsEntry // scheme://host -> robots.txt rules
+	lastMod   map[string]time.Time    // normalised URL -> sitemap lastmod
+	cssFiles  map[string]bool         // normalised URLs of the stylesheets scanned so far
+	cssTyped  map[string]bool         // normalised URLs served as text/css
+	paths     map[string]string       // saved path -> normalised URL it was saved for
+	prevState map[string]*stateLoad   // state file -> what an earlier run saved
+	state     map[string]*urlState    // normalised URL -> what this run saved
+	unchanged map[string]bool         // normalised URLs whose earlier copy was kept
+	deleted   map[string]bool         // paths --delete-after-sync removed
+	probes    map[string]*probeEntry  // normalised URL -> --spider check
+}
+
+// fileEntry makes sure each URL is fetched once however many pages refer to it
+type fileEntry struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+// statusError is the error for a response other than 200 OK
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("failed to fetch %s: %s", e.url, e.status)
+}
+
+// crawlItem is a page waiting in the crawl frontier
+type crawlItem struct {
+	url   string
+	depth int
+}
+
 // MirrorWebsite initiates the website mirroring process. It creates a base directory
 // named after the website's domain and starts downloading the website content.
-func MirrorWebsite(baseURL string, reject []string, exclude []string, convertLinks bool) error {
+// With opts.Recursive set, HTML pages linked from it are crawled breadth-first
+// down to opts.Level. Files from other hosts go to directories named after them.
+func MirrorWebsite(baseURL string, opts MirrorOptions) error {
 	fmt.Printf("\n=== Starting mirror of %s ===\n", baseURL)
-	baseFolder, err := createDirectory(baseURL)
+	filter, err := newFileFilter(opts)
 	if err != nil {
-		return fmt.Errorf("error creating directory: %v", err)
+		return err
 	}
-	fmt.Printf("Created directory: %s\n\n", baseFolder)
-	return downloadPage(baseURL, baseFolder, reject, exclude, convertLinks)
-}
-
-// createDirectory creates a directory named after the website's domain.
-// It returns the created directory path or an error if creation fails.
-func createDirectory(baseURL string) (string, error) {
-	parsedURL, err := url.Parse(baseURL)
+	names, err := parseRestrictFileNames(opts.RestrictFileNames)
 	if err != nil {
-		return "", err
+		return err
 	}
-	domain := parsedURL.Host
-	err = os.MkdirAll(domain, 0755)
-	return domain, err
-}
-
-// downloadPage downloads a single webpage and its resources. It processes the HTML content,
-// downloads all associated resources, and updates links in the HTML if specified.
-// The page is saved maintaining the original URL path structure.
-func downloadPage(pageURL, baseFolder string, reject []string, exclude []string, convertLinks bool) error {
-	fmt.Printf("Downloading page: %s\n", pageURL)
-	resp, err := http.Get(pageURL)
-	if err != nil {
+	if err := checkSyncOptions(opts); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch page: %s", pageURL)
+	m := &mirrorer{
+		opts:      opts,
+		root:      opts.DirectoryPrefix,
+		startHost: hostName(baseURL),
+		limiter:   NewRateLimiter(opts.RateLimit),
+		sched:     newHostScheduler(opts.MaxPerHost, opts.Wait, opts.RandomWait),
+		filter:    filter,
+		names:     names,
+		budget:    newDownloadBudget(opts.Limits),
+		files:     make(map[string]*fileEntry),
+		htmlPages: make(map[string]bool),
+		rejected:  make(map[string]bool),
+		robots:    make(map[string]*robotsEntry),
+		lastMod:   make(map[string]time.Time),
+		cssFiles:  make(map[string]bool),
+		cssTyped:  make(map[string]bool),
+		paths:     make(map[string]string),
+		prevState: make(map[string]*stateLoad),
+		state:     make(map[string]*urlState),
+		unchanged: make(map[string]bool),
+		deleted:   make(map[string]bool),
+		probes:    make(map[string]*probeEntry),
+	}
+	if opts.Spider {
+		return m.spider(baseURL)
 	}
-	fmt.Printf("Got response: %s for %s\n", resp.Status, pageURL)
 
-	body, err := io.ReadAll(resp.Body)
+	baseFolder, err := createDirectory(m.fullPath("."))
 	if err != nil {
-		return err
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+	fmt.Printf("Saving mirror to: %s\n\n", baseFolder)
+
+	if m.limiter != nil {
+		fmt.Printf("Rate limit set to: %.2f KB/s\n", float64(m.limiter.Rate())/1024)
+	}
+	return m.crawl(baseURL)
+}
+
+// crawl processes pages breadth-first starting at startURL. Every page is parsed for
+// resources; the linked resources that turn out to be HTML join the frontier one level
+// deeper until the depth limit is reached. Pages are deduplicated by normalised URL.
+// With --sitemap the pages listed in the sitemaps are crawled as if they were start pages.
+// The progress is journaled as the crawl goes, and with --continue a crawl that was
+// interrupted picks up where the journal left it.
+func (m *mirrorer) crawl(startURL string) error {
+	visited := map[string]bool{normalizeURL(startURL): true}
+	frontier := []crawlItem{{url: startURL, depth: 0}}
+	var done []crawlItem
+	pages := 0
+
+	resumed := false
+	if m.opts.Continue {
+		if journal := m.loadJournal(startURL); journal != nil {
+			frontier, visited, done = m.resume(journal)
+			pages = journal.Pages
+			resumed = true
+		} else {
+			fmt.Println("No interrupted crawl to continue, starting from the beginning")
+		}
+	}
+
+	if m.opts.Sitemap && !resumed {
+		for _, page := range m.sitemapPages(startURL) {
+			key := normalizeURL(page.Loc)
+			m.lastMod[key] = page.LastMod
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+			frontier = append(frontier, crawlItem{url: page.Loc, depth: 0})
+		}
 	}
 
-	htmlContent := string(body)
-	resourceMap := downloadResources(htmlContent, pageURL, baseFolder, reject, exclude)
+	defer m.stopOnInterrupt()()
+	saved := time.Now()
+	for len(frontier) > 0 {
+		// Between two pages nothing is downloading, so the journal is consistent
+		if time.Since(saved) >= journalInterval {
+			m.saveJournal(startURL, pages, frontier, done, visited)
+			saved = time.Now()
+		}
+		if m.budget.exhausted() != "" {
+			break
+		}
+
+		item := frontier[0]
+		frontier = frontier[1:]
+		done = append(done, item)
+
+		links, err := m.downloadPage(item.url, m.followLinks(item.depth))
+		if err != nil {
+			// Without the start page there is nothing to mirror
+			if item.url == startURL {
+				return err
+			}
+			fmt.Printf("Error processing %s: %v\n", item.url, err)
+			continue
+		}
+		pages++
+
+		// Pages past the depth limit are still processed for their requisites with -p
+		if !m.opts.Recursive || !(m.followLinks(item.depth+1) || m.opts.PageRequisites) {
+			continue
+		}
+		for _, link := range links {
+			key := normalizeURL(link)
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+			frontier = append(frontier, crawlItem{url: link, depth: item.depth + 1})
+		}
+	}
 
-	if convertLinks {
-		htmlContent = updateLinks(htmlContent, resourceMap)
+	// Links are converted only now, so that links between pages resolve no matter
+	// which of them was saved first
+	m.convertPages()
+	m.removeRejected()
+	stopped := m.budget.summary()
+	if stopped != "" {
+		// Keep the rest of the frontier so --continue can carry on from here
+		m.saveJournal(startURL, pages, frontier, done, visited)
 	} else {
-		htmlContent = updateCSSJSPaths(htmlContent, resourceMap)
+		m.removeJournal()
+	}
+	var syncErr error
+	if m.opts.DeleteAfterSync {
+		syncErr = m.syncFiles()
+	}
+	// Saved after the sync, so the files it deleted are forgotten
+	m.saveState()
+	if syncErr != nil {
+		return syncErr
 	}
 
-	// Get the URL path
-	parsedURL, err := url.Parse(pageURL)
-	if err != nil {
-		return err
+	if unchanged := len(sortedKeys(&m.mu, m.unchanged)); unchanged > 0 {
+		fmt.Printf("\n%d files unchanged since the last mirror\n", unchanged)
 	}
-	// Determine the path for saving the HTML file
-	relativePath := strings.TrimPrefix(parsedURL.Path, "/")
-	fmt.Println("Relative path:", relativePath)
-	if relativePath == "" {
-		relativePath = "index.html"
-	} else if !strings.Contains(relativePath, ".") {
-		fmt.Println("No extension found, checking if content is HTML")
-		// Create a temporary file to check if content is HTML
-		tempPath := filepath.Join(baseFolder, relativePath)
-		if err := os.MkdirAll(tempPath, 0755); err != nil {
-			return fmt.Errorf("failed to create temp directory: %v", err)
-		}
-		
-		tempFile := filepath.Join(tempPath, "temp")
-		if err := os.WriteFile(tempFile, []byte(htmlContent), 0644); err != nil {
-			return fmt.Errorf("failed to write temp file: %v", err)
-		}
-		
-		// Check if the content is HTML
-		if isHTMLFile(tempFile) {
-			relativePath = filepath.Join(relativePath, "index.html")
-			fmt.Printf("Detected HTML content, using path: %s\n", relativePath)
-		}
-		
-		// Clean up temp file
-		os.Remove(tempFile)
+	fmt.Printf("\n=== Mirror finished: %d pages processed ===\n", pages)
+	if stopped != "" {
+		fmt.Printf("Stopped early, %s: run again with -c to continue\n", stopped)
 	}
+	return nil
+}
 
-	// Create all necessary directories
-	dir := filepath.Join(baseFolder, filepath.Dir(relativePath))
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directories: %v", err)
+// followLinks reports whether a page found at depth has its links to other pages
+// downloaded, rather than just its requisites. Pages one level past the limit are
+// still downloaded, just not parsed for more pages.
+func (m *mirrorer) followLinks(depth int) bool {
+	if !m.opts.Recursive {
+		// A plain mirror keeps everything the start page links to; -p only its requisites
+		return !m.opts.PageRequisites
 	}
+	return m.opts.Level == 0 || depth < m.opts.Level
+}
 
-	// Save the HTML file
-	htmlPath := filepath.Join(baseFolder, relativePath)
-	fmt.Printf("Saving HTML to: %s\n", filepath.Join(baseFolder, relativePath))
-	return os.WriteFile(htmlPath, []byte(htmlContent), 0644)
+// createDirectory creates the folder the mirror is saved in, the --directory-prefix
+// or the working directory. It returns the absolute path of the folder.
+func createDirectory(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Abs(dir)
 }
 
-// updateCSSJSPaths modifies CSS and JavaScript file paths in HTML content to use relative paths.
-// It updates both src and href attributes to point to the locally downloaded files.
-func updateCSSJSPaths(htmlContent string, resourceMap map[string]string) string {
-	for originalURL, filename := range resourceMap {
-		if strings.HasSuffix(strings.ToLower(originalURL), ".css") || strings.HasSuffix(strings.ToLower(originalURL), ".js") {
-			// Handle src attribute with both quote types
-			htmlContent = strings.ReplaceAll(htmlContent,
-				fmt.Sprintf(`src="%s"`, originalURL),
-				fmt.Sprintf(`src="./%s"`, filename))
-			htmlContent = strings.ReplaceAll(htmlContent,
-				fmt.Sprintf(`src='%s'`, originalURL),
-				fmt.Sprintf(`src='./%s'`, filename))
-			
-			// Handle href attribute for CSS files with both quote types
-			htmlContent = strings.ReplaceAll(htmlContent,
-				fmt.Sprintf(`href="%s"`, originalURL),
-				fmt.Sprintf(`href="./%s"`, filename))
-			htmlContent = strings.ReplaceAll(htmlContent,
-				fmt.Sprintf(`href='%s'`, originalURL),
-				fmt.Sprintf(`href='./%s'`, filename))
-		}
-	}
-	return htmlContent
-}
-//bool to check whether to add .html to files that don't have an extension and are html files if we look at the content
-func isHTMLFile(path string) bool {
-	content, err := os.ReadFile(path)
+// downloadPage downloads a single webpage and its resources. It processes the HTML content
+// and downloads all associated resources, or only its requisites unless followLinks is
+// set. The page is saved maintaining the original URL path structure. It returns the
+// absolute URLs of the linked resources that are HTML pages themselves.
+func (m *mirrorer) downloadPage(pageURL string, followLinks bool) ([]string, error) {
+	fmt.Printf("Downloading page: %s\n", pageURL)
+	relativePath, err := m.saveURL(pageURL, true)
 	if err != nil {
-		return false
+		return nil, err
+	}
+	if !m.isHTMLPage(pageURL) {
+		// Nothing to scan in images, archives and the like
+		return nil, nil
 	}
-	return strings.Contains(string(content), "<html") || strings.Contains(string(content), "<!DOCTYPE html")
-}
 
+	scan, err := m.scanFile(pageURL, relativePath, scanPage(pageURL))
+	if err != nil {
+		return nil, err
+	}
 
-// updateLinks modifies all resource links in HTML content to use relative paths.
-// This includes images, stylesheets, scripts, and other embedded resources.
-func updateLinks(htmlContent string, resourceMap map[string]string) string {
-	for originalURL, filename := range resourceMap {
-		htmlContent = strings.ReplaceAll(htmlContent,
-			fmt.Sprintf(`src="%s"`, originalURL),
-			fmt.Sprintf(`src="./%s"`, filename))
-		htmlContent = strings.ReplaceAll(htmlContent,
-			fmt.Sprintf(`href="%s"`, originalURL),
-			fmt.Sprintf(`href="./%s"`, filename))
-		htmlContent = strings.ReplaceAll(htmlContent,
-			fmt.Sprintf(`url(%s)`, originalURL),
-			fmt.Sprintf(`url("./%s")`, filename))
-		htmlContent = strings.ReplaceAll(htmlContent,
-			fmt.Sprintf(`url('%s')`, originalURL),
-			fmt.Sprintf(`url('./%s')`, filename))
-		htmlContent = strings.ReplaceAll(htmlContent,
-			fmt.Sprintf(`url("%s")`, originalURL),
-			fmt.Sprintf(`url("./%s")`, filename))
-	}
-	return htmlContent
-}
-
-// downloadResources scans HTML content for resources (images, scripts, stylesheets, etc.)
-// and downloads them concurrently. It maintains a map of original URLs to local file paths.
-// Uses a semaphore to limit concurrent downloads.
-func downloadResources(htmlContent, pageURL, baseFolder string, reject []string, exclude []string) map[string]string {
-	fmt.Printf("\nScanning for resources in: %s\n", pageURL)
-	resourceMap := make(map[string]string)
-	var mutex sync.Mutex
-	var wg sync.WaitGroup
+	if !m.opts.IgnoreRobots {
+		if scan.NoFollow && followLinks {
+			fmt.Printf("Not following links of %s (meta robots nofollow)\n", pageURL)
+			followLinks = false
+		}
+		if scan.NoIndex {
+			// Like a page refused by -A or -R: its links count, the page itself is not kept
+			fmt.Printf("Not keeping %s (meta robots noindex)\n", pageURL)
+			m.mu.Lock()
+			m.rejected[normalizeURL(pageURL)] = true
+			m.mu.Unlock()
+		}
+	}
 
-	semaphore := make(chan struct{}, 5)
+	// The links are already absolute, so they resolve against the page itself
+	baseURL := pageURL
+	pageLinks := make([]htmlLink, 0, len(scan.Links))
+	for _, link := range scan.Links {
+		pageLinks = append(pageLinks, htmlLink{URL: link.URL, Kind: link.Kind})
+	}
+	resourceMap := m.downloadResources(pageLinks, baseURL, followLinks)
 
-	patterns := []*regexp.Regexp{
-		regexp.MustCompile(`src=['"]([^'"]*?)['"]`),                              // src with both quote types
-		regexp.MustCompile(`href=['"]([^'"]*?)['"]`),                             // href with both quote types
-		regexp.MustCompile(`url\(['"]?([^'"()]+)['"]?\)`),                        // CSS url()
-		regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`),                         // CSS @import
-		regexp.MustCompile(`<script[^>]+src=['"]([^'"]+)['"]`),                   // script tags
-		regexp.MustCompile(`<link[^>]+href=['"]([^'"]+)['"]`),                    // link tags
-		regexp.MustCompile(`<img[^>]+src=['"]([^'"]+)['"]`),                      // img tags
-		regexp.MustCompile(`content=['"]([^'"]+\.(?:png|jpg|jpeg|gif|ico))['"]`), // meta images
+	var links []string
+	for resourceURL := range resourceMap {
+		absoluteURL := resolveURL(baseURL, resourceURL)
+		if m.isHTMLPage(absoluteURL) {
+			links = append(links, absoluteURL)
+		}
 	}
+	// Map order is random; keep the crawl order reproducible
+	sort.Strings(links)
+	return links, nil
+}
 
-	processedURLs := make(map[string]bool)
-	for _, pattern := range patterns {
-		matches := pattern.FindAllStringSubmatch(htmlContent, -1)
-		for _, match := range matches {
-			if len(match) < 2 {
+// scanPage returns the scan of the page at pageURL for scanFile: its meta robots
+// flags and its links, resolved against its <base href>
+func scanPage(pageURL string) func([]byte, func(string, string) string) fileScan {
+	return func(body []byte, resolve func(baseURL, link string) string) fileScan {
+		var scan fileScan
+		scan.NoIndex, scan.NoFollow = metaRobots(body)
+		baseURL := documentBase(body, pageURL)
+		for _, link := range extractLinks(body) {
+			if shouldSkipResource(link.URL) {
 				continue
 			}
-			resourceURL := match[1]
-
-			if processedURLs[resourceURL] {
-				continue
+			if absoluteURL := resolve(baseURL, link.URL); absoluteURL != "" {
+				scan.Links = append(scan.Links, stateLink{URL: absoluteURL, Kind: link.Kind})
 			}
-			processedURLs[resourceURL] = true
+		}
+		return scan
+	}
+}
 
-			if shouldSkipResource(resourceURL) {
+// scanStylesheet returns the scan of the stylesheet at cssURL for scanFile: its url()
+// and @import references
+func scanStylesheet(cssURL string) func([]byte, func(string, string) string) fileScan {
+	return func(body []byte, resolve func(baseURL, link string) string) fileScan {
+		var scan fileScan
+		for _, ref := range scanCSS(body) {
+			if shouldSkipResource(ref.URL) {
 				continue
 			}
+			if absoluteURL := resolve(cssURL, ref.URL); absoluteURL != "" {
+				scan.Links = append(scan.Links, stateLink{URL: absoluteURL, Kind: requisiteLink, Import: ref.Import})
+			}
+		}
+		return scan
+	}
+}
 
-			absoluteURL := resolveURL(pageURL, resourceURL)
-			if absoluteURL == "" || !isSameDomain(pageURL, absoluteURL) {
-				continue
+// convertPages rewrites the links of every HTML file saved by the crawl. With
+// --convert-links each link points to the local copy, relative to the page, or to the
+// absolute URL when the target was not downloaded, and the url() and @import
+// references of saved stylesheets are converted the same way. Otherwise only scripts
+// and stylesheets are pointed at their local copies.
+func (m *mirrorer) convertPages() {
+	for _, pageURL := range sortedKeys(&m.mu, m.htmlPages) {
+		m.convertFile(pageURL, func(body []byte, localLink func(string) (string, bool)) []byte {
+			baseURL := documentBase(body, pageURL)
+			links := m.translateLinks(pageURL, extractLinks(body))
+			if m.opts.ConvertLinks {
+				return clearBase(updateLinks(body, baseURL, links, localLink))
 			}
+			return updateCSSJSPaths(body, baseURL, links, localLink)
+		})
+	}
 
-			wg.Add(1)
-			go func(absURL, resURL string) {
-				defer wg.Done()
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
-
-				if filename, err := downloadFile(absURL, baseFolder, reject, exclude); err == nil {
-					mutex.Lock()
-					resourceMap[resURL] = filename
-					mutex.Unlock()
-
-					if strings.HasSuffix(strings.ToLower(filename), ".css") {
-						if cssContent, err := os.ReadFile(filepath.Join(baseFolder, filename)); err == nil {
-							cssResources := downloadCSSResources(string(cssContent), absURL, baseFolder, reject, exclude)
-							mutex.Lock()
-							for k, v := range cssResources {
-								resourceMap[k] = v
-							}
-							mutex.Unlock()
-						}
-					}
-				}
-			}(absoluteURL, resourceURL)
+	if !m.opts.ConvertLinks {
+		return
+	}
+	for _, cssURL := range sortedKeys(&m.mu, m.cssFiles) {
+		m.convertFile(cssURL, func(body []byte, localLink func(string) (string, bool)) []byte {
+			return updateLinks(body, cssURL, m.translateLinks(cssURL, cssLinks(body, 0, len(body), "", "")), localLink)
+		})
+	}
+}
+
+// documentBase returns the URL the links of a page are resolved against: its
+// <base href>, itself resolved against the page URL, or else the page URL
+func documentBase(body []byte, pageURL string) string {
+	link, ok := baseLink(body)
+	if !ok || link.URL == "" {
+		return pageURL
+	}
+	if baseURL := resolveURL(pageURL, link.URL); baseURL != "" {
+		return baseURL
+	}
+	return pageURL
+}
+
+// clearBase empties the <base href> of a converted page. Its links are now relative
+// to the local copy, which a remote base would send back to the server.
+func clearBase(body []byte) []byte {
+	link, ok := baseLink(body)
+	if !ok || link.URL == "" {
+		return body
+	}
+	return rewriteLinks(body, []htmlLink{link}, func(htmlLink) (string, bool) { return "", true })
+}
+
+// convertFile rewrites the saved copy of fileURL with convert. The localLink it is
+// given maps an absolute URL to its local copy, relative to the file being converted.
+func (m *mirrorer) convertFile(fileURL string, convert func(body []byte, localLink func(string) (string, bool)) []byte) {
+	filePath, ok := m.localFile(fileURL)
+	if !ok {
+		return
+	}
+	fullPath := m.fullPath(filePath)
+	body, err := os.ReadFile(fullPath)
+	if err != nil {
+		fmt.Printf("Error converting links in %s: %v\n", fullPath, err)
+		return
+	}
+
+	// What each written value stands for is remembered, so the next run can convert
+	// the file again if it is unchanged
+	converted := make(map[string]string)
+	localLink := func(absoluteURL string) (string, bool) {
+		target, ok := m.localFile(absoluteURL)
+		if !ok {
+			return "", false
 		}
+		value := escapeLocalPath(relativeLink(filePath, target))
+		converted[value] = absoluteURL
+		return value, true
 	}
 
-	wg.Wait()
-	return resourceMap
+	fmt.Printf("Converting links in: %s\n", fullPath)
+	if err := os.WriteFile(fullPath, convert(body, localLink), 0644); err != nil {
+		fmt.Printf("Error converting links in %s: %v\n", fullPath, err)
+		return
+	}
+	m.setConverted(fileURL, converted)
+}
+
+// translateLinks points the links of a file kept from an earlier run that were
+// converted then back at the absolute URLs they stood for
+func (m *mirrorer) translateLinks(fileURL string, links []htmlLink) []htmlLink {
+	converted := m.convertedLinks(fileURL)
+	if len(converted) == 0 {
+		return links
+	}
+	for i := range links {
+		if absoluteURL, ok := translateLink(converted, links[i].URL); ok {
+			links[i].URL = absoluteURL
+			links[i].Converted = true
+		}
+	}
+	return links
+}
+
+// sortedKeys returns the keys of set, read under mu, in sorted order
+func sortedKeys(mu *sync.Mutex, set map[string]bool) []string {
+	mu.Lock()
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	mu.Unlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// localFile returns where the content of rawURL was saved, relative to the mirror root,
+// if it was downloaded successfully
+func (m *mirrorer) localFile(rawURL string) (string, bool) {
+	m.mu.Lock()
+	entry, ok := m.files[normalizeURL(rawURL)]
+	m.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	// Only ever called once the crawl is over, so the download is complete
+	entry.once.Do(func() {})
+	if entry.err != nil || entry.path == "" || m.isRejected(rawURL) {
+		return "", false
+	}
+	return entry.path, true
+}
+
+// relativeLink returns the link from the file at fromPath to the file at toPath, both
+// slash-separated and relative to the same folder
+func relativeLink(fromPath, toPath string) string {
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(fromPath)), filepath.FromSlash(toPath))
+	if err != nil {
+		return toPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isRejected reports whether rawURL was downloaded only to follow its links
+func (m *mirrorer) isRejected(rawURL string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rejected[normalizeURL(rawURL)]
+}
+
+// isHTMLPage reports whether the content downloaded for pageURL was HTML
+func (m *mirrorer) isHTMLPage(pageURL string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.htmlPages[normalizeURL(pageURL)]
+}
+
+// updateCSSJSPaths modifies CSS and JavaScript file paths in HTML content to use relative paths.
+// It updates the src of <script> tags and the href of stylesheet <link> tags to point to
+// the locally downloaded files, as found by localLink.
+func updateCSSJSPaths(htmlContent []byte, pageURL string, links []htmlLink, localLink func(absoluteURL string) (string, bool)) []byte {
+	return rewriteLinks(htmlContent, links, func(link htmlLink) (string, bool) {
+		if !isCSSJSLink(link) || shouldSkipResource(link.URL) {
+			return "", false
+		}
+		absoluteURL := resolveURL(pageURL, link.URL)
+		if absoluteURL == "" {
+			return "", false
+		}
+		if local, ok := localLink(absoluteURL); ok {
+			return local, true
+		}
+		// A link converted by an earlier run must not keep pointing at a missing file
+		return absoluteURL, link.Converted
+	})
+}
+
+// isCSSJSLink reports whether link loads a script or a stylesheet
+func isCSSJSLink(link htmlLink) bool {
+	return (link.Tag == "script" && link.Attr == "src") ||
+		(link.Tag == "link" && link.Kind == requisiteLink && strings.HasSuffix(strings.ToLower(link.URL), ".css"))
+}
+
+// updateLinks modifies all resource links in HTML content to use relative paths.
+// This includes images, stylesheets, scripts, and other embedded resources. Links to
+// anything localLink does not know are made absolute so they keep working offline.
+func updateLinks(htmlContent []byte, pageURL string, links []htmlLink, localLink func(absoluteURL string) (string, bool)) []byte {
+	return rewriteLinks(htmlContent, links, func(link htmlLink) (string, bool) {
+		// The site root is never fetched as a resource but is still worth converting
+		if shouldSkipResource(link.URL) && link.URL != "/" {
+			return "", false
+		}
+		absoluteURL := resolveURL(pageURL, link.URL)
+		if absoluteURL == "" {
+			return "", false
+		}
+		target, fragment, _ := strings.Cut(absoluteURL, "#")
+		if local, ok := localLink(target); ok {
+			if fragment != "" {
+				local += "#" + fragment
+			}
+			return local, true
+		}
+		return absoluteURL, absoluteURL != link.URL || link.Converted
+	})
 }
 
-// downloadCSSResources scans CSS content for referenced resources (like images and fonts)
-// and downloads them concurrently. Similar to downloadResources but specific to CSS files.
-func downloadCSSResources(cssContent, baseURL, baseFolder string, reject []string, exclude []string) map[string]string {
-	fmt.Printf("Scanning CSS for resources from: %s\n", baseURL)
+// downloadResources downloads the resources (images, scripts, stylesheets, etc.) found by
+// extractLinks concurrently, resolving them against baseURL. It maintains a map of
+// original URLs to local file paths. Links to other pages are skipped unless
+// followLinks is set.
+// Concurrency per host is capped by the mirror's shared scheduler.
+func (m *mirrorer) downloadResources(links []htmlLink, baseURL string, followLinks bool) map[string]string {
+	fmt.Printf("\nScanning for resources in: %s\n", baseURL)
 	resourceMap := make(map[string]string)
 	var mutex sync.Mutex
 	var wg sync.WaitGroup
 
-	patterns := []*regexp.Regexp{
-		regexp.MustCompile(`url\(['"]?([^'"()]+)['"]?\)`),
-		regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`),
-	}
+	processedURLs := make(map[string]bool)
+	for _, link := range links {
+		resourceURL := link.URL
+		if link.Kind == pageLink && !followLinks {
+			continue
+		}
 
-	semaphore := make(chan struct{}, 5)
+		if processedURLs[resourceURL] {
+			continue
+		}
+		processedURLs[resourceURL] = true
 
-	for _, pattern := range patterns {
-		matches := pattern.FindAllStringSubmatch(cssContent, -1)
-		for _, match := range matches {
-			if len(match) < 2 {
-				continue
-			}
-			resourceURL := match[1]
+		if shouldSkipResource(resourceURL) {
+			continue
+		}
 
-			if shouldSkipResource(resourceURL) {
-				continue
-			}
+		absoluteURL := resolveURL(baseURL, resourceURL)
+		if absoluteURL == "" || !m.acceptHost(absoluteURL) {
+			continue
+		}
 
-			absoluteURL := resolveURL(baseURL, resourceURL)
-			if absoluteURL == "" || !isSameDomain(baseURL, absoluteURL) {
-				continue
-			}
+		wg.Add(1)
+		go func(absURL, resURL string, kind linkKind) {
+			defer wg.Done()
 
-			wg.Add(1)
-			go func(absURL, resURL string) {
-				defer wg.Done()
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
+			if filename, err := m.downloadFile(absURL, kind); err == nil {
+				mutex.Lock()
+				resourceMap[resURL] = filename
+				mutex.Unlock()
 
-				if filename, err := downloadFile(absURL, baseFolder, reject, exclude); err == nil {
-					mutex.Lock()
-					resourceMap[resURL] = filename
-					mutex.Unlock()
+				if m.isStylesheet(absURL, filename) {
+					m.processCSS(absURL, filename)
 				}
-			}(absoluteURL, resourceURL)
-		}
+			}
+		}(absoluteURL, resourceURL, link.Kind)
 	}
 
 	wg.Wait()
 	return resourceMap
 }
 
+// processCSS downloads what the stylesheet saved at cssPath refers to, resolved
+// against cssURL, and recurses into the stylesheets it imports. Each stylesheet is
+// scanned once, so @import cycles end.
+func (m *mirrorer) processCSS(cssURL, cssPath string) {
+	key := normalizeURL(cssURL)
+	m.mu.Lock()
+	seen := m.cssFiles[key]
+	m.cssFiles[key] = true
+	m.mu.Unlock()
+	if seen {
+		return
+	}
+
+	scan, err := m.scanFile(cssURL, cssPath, scanStylesheet(cssURL))
+	if err != nil {
+		fmt.Printf("Error reading stylesheet %s: %v\n", cssPath, err)
+		return
+	}
+
+	fmt.Printf("Scanning CSS for resources from: %s\n", cssURL)
+	var wg sync.WaitGroup
+	processed := make(map[string]bool)
+	for _, ref := range scan.Links {
+		if processed[ref.URL] || !m.acceptHost(ref.URL) {
+			continue
+		}
+		processed[ref.URL] = true
+
+		wg.Add(1)
+		go func(absURL string, isImport bool) {
+			defer wg.Done()
+
+			filename, err := m.downloadFile(absURL, requisiteLink)
+			if err == nil && (isImport || m.isStylesheet(absURL, filename)) {
+				m.processCSS(absURL, filename)
+			}
+		}(ref.URL, ref.Import)
+	}
+	wg.Wait()
+}
+
+// isStylesheet reports whether the file saved for fileURL is CSS, going by the
+// Content-Type it was served with or else its name
+func (m *mirrorer) isStylesheet(fileURL, filename string) bool {
+	m.mu.Lock()
+	typed := m.cssTyped[normalizeURL(fileURL)]
+	m.mu.Unlock()
+	return typed || strings.HasSuffix(strings.ToLower(filename), ".css")
+}
+
 // shouldSkipResource checks if a resource URL should be skipped based on its scheme
 // or if it's a special URL type (like data: URLs, javascript:, mailto:, etc.)
 func shouldSkipResource(resourceURL string) bool {
@@ -307,89 +718,348 @@ func shouldSkipResource(resourceURL string) bool {
 }
 
 // downloadFile downloads a single file from fileURL and saves it to the appropriate
-// location in baseFolder, maintaining the original path structure.
-// Returns the relative path to the downloaded file or an error.
-func downloadFile(fileURL, baseFolder string, reject []string, exclude []string) (string, error) {
-	if !shouldDownloadFile(fileURL, reject, exclude) {
+// location in its host's folder, maintaining the original path structure.
+// Returns the path to the downloaded file relative to the mirror root or an error.
+// A page refused by -A or -R is still downloaded when recursing, so the crawl can
+// read its links, and removed once the crawl is over.
+func (m *mirrorer) downloadFile(fileURL string, kind linkKind) (string, error) {
+	allowed := m.filter.allowsURL(fileURL)
+	if allowed && !m.filter.allowsName(fileURL) {
+		allowed = false
+		if kind == pageLink && m.opts.Recursive && looksLikePage(fileURL) {
+			m.mu.Lock()
+			m.rejected[normalizeURL(fileURL)] = true
+			m.mu.Unlock()
+			allowed = true
+		}
+	}
+	if !allowed {
 		fmt.Printf("Skipping filtered file: %s\n", fileURL)
 		return "", fmt.Errorf("file filtered out: %s", fileURL)
 	}
+	if !m.robotsAllowed(fileURL) {
+		fmt.Printf("Skipping %s, disallowed by robots.txt\n", fileURL)
+		return "", fmt.Errorf("disallowed by robots.txt: %s", fileURL)
+	}
+	// Linked pages are downloaded here, before the crawl reaches them
+	return m.saveURL(fileURL, kind == pageLink && looksLikePage(fileURL))
+}
+
+// removeRejected deletes the pages that were only downloaded to follow their links
+func (m *mirrorer) removeRejected() {
+	m.mu.Lock()
+	var rejected []string
+	for key := range m.rejected {
+		rejected = append(rejected, key)
+	}
+	m.mu.Unlock()
+	sort.Strings(rejected)
+
+	for _, key := range rejected {
+		m.mu.Lock()
+		entry, ok := m.files[key]
+		m.mu.Unlock()
+		if !ok {
+			continue
+		}
+		entry.once.Do(func() {})
+		if entry.err != nil || entry.path == "" {
+			continue
+		}
+		fmt.Printf("Removing %s since it should be rejected\n", m.fullPath(entry.path))
+		os.Remove(m.fullPath(entry.path))
+	}
+}
+
+// saveURL fetches fileURL into the mirror unless an earlier call already did, in
+// which case the earlier result is returned. Concurrent callers wait for the first.
+// A page takes one of the --max-pages when it is first fetched.
+func (m *mirrorer) saveURL(fileURL string, page bool) (string, error) {
+	key := normalizeURL(fileURL)
+
+	m.mu.Lock()
+	entry, ok := m.files[key]
+	if !ok {
+		entry = &fileEntry{}
+		m.files[key] = entry
+	}
+	m.mu.Unlock()
 
+	entry.once.Do(func() {
+		if page && !m.budget.take() {
+			reason := m.budget.stopped()
+			fmt.Printf("Skipping %s, %s reached\n", fileURL, reason)
+			entry.err = fmt.Errorf("not downloaded, %s reached", reason)
+			return
+		}
+		entry.path, entry.err = m.fetchFile(fileURL)
+	})
+	return entry.path, entry.err
+}
+
+// fetchFile does the actual download for saveURL. The body goes to a temporary file
+// first because where it is saved depends on its type, from the Content-Type header
+// or else the body itself: an extensionless URL holding HTML is saved as
+// <path>/index.html. A file saved by an earlier run is requested conditionally and
+// kept when the server answers 304 or sends the same bytes again.
+func (m *mirrorer) fetchFile(fileURL string) (string, error) {
 	fmt.Printf("Downloading resource: %s\n", fileURL)
-	resp, err := http.Get(fileURL)
+	if !m.opts.IgnoreRobots {
+		// Loads the host's Crawl-delay before its first request
+		m.robotsFor(fileURL)
+	}
+	prev := m.previous(fileURL)
+	if prev != nil && !m.reservePath(fileURL, prev.Path) {
+		// Another URL of this run has the name now, so the copy cannot be kept
+		prev = nil
+	}
+	if relativePath, ok := m.upToDate(fileURL); ok {
+		if prev == nil && m.reservePath(fileURL, relativePath) {
+			// A copy the state file does not know, tracked from now on
+			fmt.Printf("Not modified since its sitemap lastmod: %s\n", fileURL)
+			prev = &urlState{Path: relativePath, Kind: detectContent("", readHead(m.fullPath(relativePath)))}
+		}
+		if prev != nil && prev.Path == relativePath {
+			return m.keepPrevious(fileURL, prev), nil
+		}
+	}
+
+	req, err := newCrawlRequest(http.MethodGet, fileURL)
 	if err != nil {
-		fmt.Printf("Error downloading %s: %v\n", fileURL, err)
 		return "", err
 	}
-	defer resp.Body.Close()
+	if prev != nil {
+		// Let the server answer 304 if nothing changed since the last mirror
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
 
-	u, err := url.Parse(fileURL)
+	release := m.sched.acquireURL(fileURL)
+	defer release()
+	// Checked once the request may go, downloads already running are finished and
+	// no new one starts
+	if reason := m.budget.exhausted(); reason != "" {
+		fmt.Printf("Skipping %s, %s reached\n", fileURL, reason)
+		return "", fmt.Errorf("not downloaded, %s reached", reason)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		fmt.Printf("Error downloading %s: %v\n", fileURL, err)
 		return "", err
 	}
+	defer resp.Body.Close()
 
-	// Get the path without leading slash
-	relativePath := strings.TrimPrefix(u.Path, "/")
-	if relativePath == "" {
-		return "", fmt.Errorf("empty path")
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		return m.keepPrevious(fileURL, prev), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{url: fileURL, status: resp.Status, code: resp.StatusCode}
+	}
+	fmt.Printf("Got response: %s for %s\n", resp.Status, fileURL)
+	if err := m.budget.checkSize(resp.ContentLength); err != nil {
+		fmt.Printf("Skipping %s: %v\n", fileURL, err)
+		return "", err
 	}
 
-	// Create the full path including folders
-	fullPath := filepath.Join(baseFolder, relativePath)
-
-	// Create all necessary directories
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directories: %v", err)
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", err
 	}
 
-	// Create and write to the file
-	out, err := os.Create(fullPath)
+	// Create and write to a temporary file
+	out, err := os.CreateTemp(m.fullPath("."), ".wget-tmp-*")
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %v", err)
 	}
-	defer out.Close()
+	tempPath := out.Name()
+	defer os.Remove(tempPath) // no-op once renamed
 
-	_, err = io.Copy(out, resp.Body)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), m.budget.reader(m.limitReader(resp.Body)))
+	out.Close()
+	if errors.Is(err, errFileTooLarge) {
+		fmt.Printf("Skipping %s: %v\n", fileURL, err)
+		return "", err
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to write file: %v", err)
 	}
 
+	// Where the file goes depends on whether it is a page, which the headers say
+	kind := detectContent(resp.Header.Get("Content-Type"), readHead(tempPath))
+	relativePath := m.claimPath(fileURL, m.localPath(u, kind))
+	if err := insideRoot(relativePath); err != nil {
+		return "", err
+	}
+
+	entry := &urlState{
+		Path:         relativePath,
+		Kind:         kind,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+	}
+	if prev != nil && prev.Path == entry.Path && prev.SHA256 == entry.SHA256 {
+		// Same bytes as last time, so the saved copy, converted or not, is still good
+		prev.ETag, prev.LastModified = entry.ETag, entry.LastModified
+		return m.keepPrevious(fileURL, prev), nil
+	}
+
+	// Create all necessary directories
+	fullPath := m.fullPath(relativePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directories: %v", err)
+	}
+	if err := os.Rename(tempPath, fullPath); err != nil {
+		return "", fmt.Errorf("failed to save file: %v", err)
+	}
+
+	m.recordContent(fileURL, kind)
+	m.setState(fileURL, entry, false)
+
 	// After successful download
-	fmt.Printf("Successfully downloaded: %s -> %s/%s\n", fileURL, baseFolder, relativePath)
+	fmt.Printf("Successfully downloaded: %s -> %s\n", fileURL, fullPath)
 	return relativePath, nil
 }
 
-// shouldDownloadFile checks if a file should be downloaded based on reject and exclude patterns.
-// Returns false if the file matches any reject pattern or exclude pattern.
-func shouldDownloadFile(fileURL string, reject []string, exclude []string) bool {
-	u, err := url.Parse(fileURL)
-	if err != nil {
-		return false
+// localPath is where the file for u is saved, relative to the mirror root. By
+// default every host gets its own folder holding the URL path. An extensionless
+// page becomes <path>/index.html, or <path>.html with --adjust-extension.
+func (m *mirrorer) localPath(u *url.URL, kind contentKind) string {
+	var relativePath string
+	if m.opts.AdjustExtension {
+		relativePath = adjustExtension(urlToPath(u, false, m.names), kind)
+	} else {
+		relativePath = urlToPath(u, kind == htmlContent, m.names)
 	}
 
-	urlPath := u.Path
+	dir, name := path.Split(relativePath)
+	if m.opts.NoDirectories {
+		return name
+	}
 
-	// First check rejects - if any match, don't download
-	for _, pattern := range reject {
-		if strings.Contains(urlPath, pattern) {
-			return false
+	var dirs []string
+	if m.opts.ProtocolDirectories {
+		dirs = append(dirs, escapeFileName(u.Scheme, m.names))
+	}
+	if !m.opts.NoHostDirectories {
+		dirs = append(dirs, escapeFileName(u.Host, m.names))
+	}
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		parts := strings.Split(dir, "/")
+		if m.opts.CutDirs >= len(parts) {
+			parts = nil
+		} else {
+			parts = parts[m.opts.CutDirs:]
 		}
+		dirs = append(dirs, parts...)
 	}
+	return path.Join(append(dirs, name)...)
+}
 
-	// If exclude patterns exist, return false only if the path matches an exclude pattern
-	if len(exclude) > 0 {
-		for _, pattern := range exclude {
-			if strings.HasPrefix(urlPath, pattern) {
-				return false // Skip this file as it matches exclude pattern
-			}
+// claimPath reserves relativePath for fileURL. When another URL already has it,
+// which flattening with -nd, -nH or --cut-dirs makes likely, a number is added
+// before the extension until the name is free: index.html, index.1.html, ...
+func (m *mirrorer) claimPath(fileURL, relativePath string) string {
+	key := normalizeURL(fileURL)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ext := path.Ext(relativePath)
+	stem := strings.TrimSuffix(relativePath, ext)
+	candidate := relativePath
+	for n := 1; ; n++ {
+		owner, taken := m.paths[candidate]
+		if !taken || owner == key {
+			m.paths[candidate] = key
+			return candidate
 		}
+		candidate = fmt.Sprintf("%s.%d%s", stem, n, ext)
 	}
+}
 
-	// If we get here, the file should be downloaded
+// reservePath claims relativePath for fileURL as it is, reporting false when another
+// URL already has it
+func (m *mirrorer) reservePath(fileURL, relativePath string) bool {
+	key := normalizeURL(fileURL)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner, taken := m.paths[relativePath]; taken && owner != key {
+		return false
+	}
+	m.paths[relativePath] = key
 	return true
 }
 
+// recordContent remembers which downloaded URLs are pages and stylesheets
+func (m *mirrorer) recordContent(fileURL string, kind contentKind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch kind {
+	case htmlContent:
+		m.htmlPages[normalizeURL(fileURL)] = true
+	case cssContent:
+		m.cssTyped[normalizeURL(fileURL)] = true
+	}
+}
+
+// fullPath turns a slash-separated path relative to the mirror root into a file path
+func (m *mirrorer) fullPath(relativePath string) string {
+	return filepath.Join(m.root, filepath.FromSlash(relativePath))
+}
+
+// acceptHost reports whether resourceURL is on a host the mirror may download from:
+// the start host, or with -H any host, or with -D any host under the listed domains.
+// Hosts under --exclude-domains are always refused.
+func (m *mirrorer) acceptHost(resourceURL string) bool {
+	host := hostName(resourceURL)
+	if host == "" || hostMatches(host, m.opts.ExcludeDomains) {
+		return false
+	}
+	if host == m.startHost {
+		return true
+	}
+	if len(m.opts.Domains) > 0 {
+		return hostMatches(host, m.opts.Domains)
+	}
+	return m.opts.SpanHosts
+}
+
+// hostName returns the lowercased host of rawURL without its port
+func hostName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// hostMatches reports whether host is one of domains or a subdomain of one of them
+func hostMatches(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitReader wraps r so that it draws from the mirror's shared rate limiter
+func (m *mirrorer) limitReader(r io.Reader) io.Reader {
+	if m.limiter == nil {
+		return r
+	}
+	return NewSharedRateLimitReader(r, m.limiter)
+}
+
 // resolveURL converts a relative URL to an absolute URL using the base URL.
 // Handles both absolute URLs and relative URLs (with or without leading slash).
 func resolveURL(baseURL, resourcePath string) string {
@@ -402,11 +1072,6 @@ func resolveURL(baseURL, resourcePath string) string {
 		return ""
 	}
 
-	if strings.HasPrefix(resourcePath, "/") {
-		base.Path = resourcePath
-		return base.String()
-	}
-
 	rel, err := url.Parse(resourcePath)
 	if err != nil {
 		return ""
@@ -415,18 +1080,25 @@ func resolveURL(baseURL, resourcePath string) string {
 	return base.ResolveReference(rel).String()
 }
 
-// isSameDomain checks if two URLs belong to the same domain.
-// Used to ensure we only download resources from the target website.
-func isSameDomain(baseURL, resourceURL string) bool {
-	base, err := url.Parse(baseURL)
+// normalizeURL returns the form of rawURL used to recognise URLs already seen: the
+// scheme and host are lowercased, default ports and fragments dropped and an empty
+// path becomes "/".
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return false
+		return rawURL
 	}
 
-	resource, err := url.Parse(resourceURL)
-	if err != nil {
-		return false
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) ||
+		(u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
 	}
-
-	return base.Host == resource.Host
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
 }
@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSite serves a small site from a map of path to HTML
+func newTestSite(pages map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+// chdirTemp moves the test into a fresh directory, since mirrors are saved
+// relative to the working directory
+func chdirTemp(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("could not get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("could not change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestMirrorWebsiteRecursive(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":         `<html><body><a href="/a.html">A</a> <a href="/about">About</a><img src="/logo.png"></body></html>`,
+		"/a.html":   `<html><body><a href="c.html">C</a></body></html>`,
+		"/about":    `<html><body><a href="/">Home</a></body></html>`,
+		"/c.html":   `<html><body><a href="/d.html">D</a></body></html>`,
+		"/d.html":   `<html><body>too deep</body></html>`,
+		"/logo.png": "PNG",
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, Level: 2})
+	if err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	for _, want := range []string{"index.html", "a.html", "about/index.html", "c.html", "logo.png"} {
+		if _, err := os.Stat(filepath.Join(host.Host, want)); err != nil {
+			t.Errorf("expected %s to be mirrored: %v", want, err)
+		}
+	}
+	// c.html sits at depth 2, so its links are not followed
+	if _, err := os.Stat(filepath.Join(host.Host, "d.html")); !os.IsNotExist(err) {
+		t.Errorf("d.html is beyond the depth limit and should not be mirrored")
+	}
+}
+
+func TestMirrorWebsiteNotRecursive(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":       `<html><body><a href="/a.html">A</a></body></html>`,
+		"/a.html": `<html><body><a href="/b.html">B</a></body></html>`,
+		"/b.html": `<html><body>B</body></html>`,
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	if err := MirrorWebsite(server.URL, MirrorOptions{}); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	if _, err := os.Stat(filepath.Join(host.Host, "a.html")); err != nil {
+		t.Errorf("a.html should be downloaded as a resource of the start page: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(host.Host, "b.html")); !os.IsNotExist(err) {
+		t.Errorf("b.html should not be downloaded without recursion")
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"HTTP://Example.COM", "http://example.com/"},
+		{"http://example.com:80/a#top", "http://example.com/a"},
+		{"https://example.com:443/a?x=1", "https://example.com/a?x=1"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeURL(tt.input); got != tt.expected {
+			t.Errorf("normalizeURL(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMirrorWebsiteConvertLinksNested(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":             `<html><body><a href="/docs/guide/">Guide</a></body></html>`,
+		"/docs/guide/":  `<html><head><link rel="stylesheet" href="/css/site.css"></head><body><a href="/">Home</a> <a href="/deep.html">Deep</a></body></html>`,
+		"/css/site.css": `body { color: black; }`,
+		"/deep.html":    `<html><body>deep</body></html>`,
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, Level: 1, ConvertLinks: true})
+	if err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	guide, err := os.ReadFile(filepath.Join(host.Host, "docs", "guide", "index.html"))
+	if err != nil {
+		t.Fatalf("guide page was not saved: %v", err)
+	}
+
+	// The guide sits at depth 1, so its stylesheet and links were never downloaded
+	expected := `<html><head><link rel="stylesheet" href="` + server.URL + `/css/site.css"></head><body><a href="../../index.html">Home</a> <a href="` + server.URL + `/deep.html">Deep</a></body></html>`
+	if string(guide) != expected {
+		t.Errorf("converted guide page:\n%s\nwant:\n%s", guide, expected)
+	}
+
+	index, err := os.ReadFile(filepath.Join(host.Host, "index.html"))
+	if err != nil {
+		t.Fatalf("start page was not saved: %v", err)
+	}
+	if expected := `<a href="docs/guide/index.html">Guide</a>`; !strings.Contains(string(index), expected) {
+		t.Errorf("start page %s does not contain %s", index, expected)
+	}
+}
+
+func TestMirrorWebsitePageRequisites(t *testing.T) {
+	cdn := newTestSite(map[string]string{
+		"/lib.js": `console.log("cdn")`,
+	})
+	defer cdn.Close()
+
+	server := newTestSite(map[string]string{
+		"/article.html": `<html><head><link rel="stylesheet" href="/css/a.css"><script src="` + cdn.URL + `/lib.js"></script></head>` +
+			`<body><img src="/img/a.png"><a href="/next.html">Next</a></body></html>`,
+		"/css/a.css":  `body { background: url(../img/bg.png); }`,
+		"/img/a.png":  "PNG",
+		"/img/bg.png": "PNG",
+		"/next.html":  `<html><body>next</body></html>`,
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/article.html", MirrorOptions{PageRequisites: true, SpanHosts: true, ConvertLinks: true})
+	if err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	cdnHost, _ := url.Parse(cdn.URL)
+	for _, want := range []string{
+		filepath.Join(host.Host, "article.html"),
+		filepath.Join(host.Host, "css", "a.css"),
+		filepath.Join(host.Host, "img", "a.png"),
+		filepath.Join(host.Host, "img", "bg.png"),
+		filepath.Join(cdnHost.Host, "lib.js"),
+	} {
+		if _, err := os.Stat(want); err != nil {
+			t.Errorf("expected requisite %s: %v", want, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(host.Host, "next.html")); !os.IsNotExist(err) {
+		t.Errorf("-p should not follow links to other pages")
+	}
+
+	article, _ := os.ReadFile(filepath.Join(host.Host, "article.html"))
+	if expected := `src="../` + cdnHost.Host + `/lib.js"`; !strings.Contains(string(article), expected) {
+		t.Errorf("article %s does not contain %s", article, expected)
+	}
+}
+
+func TestAcceptHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     MirrorOptions
+		url      string
+		expected bool
+	}{
+		{"start host", MirrorOptions{}, "http://www.example.com/a", true},
+		{"start host other port", MirrorOptions{}, "http://www.example.com:8080/a", true},
+		{"bare domain is foreign", MirrorOptions{}, "http://example.com/a", false},
+		{"span hosts", MirrorOptions{SpanHosts: true}, "http://other.org/a", true},
+		{"domain list subdomain", MirrorOptions{Domains: []string{"example.com"}}, "http://cdn.example.com/a", true},
+		{"domain list bare", MirrorOptions{Domains: []string{"example.com"}}, "http://example.com/a", true},
+		{"domain list suffix only", MirrorOptions{Domains: []string{"example.com"}}, "http://badexample.com/a", false},
+		{"domain list limits span", MirrorOptions{SpanHosts: true, Domains: []string{"example.com"}}, "http://other.org/a", false},
+		{"excluded", MirrorOptions{SpanHosts: true, ExcludeDomains: []string{"ads.example.com"}}, "http://x.ads.example.com/a", false},
+		{"excluded start host", MirrorOptions{ExcludeDomains: []string{"example.com"}}, "http://www.example.com/a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mirrorer{opts: tt.opts, startHost: hostName("http://www.example.com/")}
+			if got := m.acceptHost(tt.url); got != tt.expected {
+				t.Errorf("acceptHost(%q) = %v; want %v", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMirrorWebsiteCSSImports(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":                `<html><head><link rel="stylesheet" href="/css/main.css"></head><body></body></html>`,
+		"/css/main.css":    `@import "/css/parts/a.css"; body { background: url(img/bg.png); }`,
+		"/css/parts/a.css": `@import url("../main.css"); @font-face { src: url('/fonts/f.woff2'); } .x { background: url(missing.png); }`,
+		"/css/img/bg.png":  "PNG",
+		"/fonts/f.woff2":   "WOFF",
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/", MirrorOptions{PageRequisites: true, ConvertLinks: true})
+	if err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	for _, want := range []string{"css/main.css", "css/parts/a.css", "css/img/bg.png", "fonts/f.woff2"} {
+		if _, err := os.Stat(filepath.Join(host.Host, want)); err != nil {
+			t.Errorf("expected %s to be mirrored: %v", want, err)
+		}
+	}
+
+	main, err := os.ReadFile(filepath.Join(host.Host, "css", "main.css"))
+	if err != nil {
+		t.Fatalf("main.css was not saved: %v", err)
+	}
+	if expected := `@import "parts/a.css"; body { background: url(img/bg.png); }`; string(main) != expected {
+		t.Errorf("converted main.css:\n%s\nwant:\n%s", main, expected)
+	}
+
+	part, err := os.ReadFile(filepath.Join(host.Host, "css", "parts", "a.css"))
+	if err != nil {
+		t.Fatalf("a.css was not saved: %v", err)
+	}
+	expected := `@import url("../main.css"); @font-face { src: url('../../fonts/f.woff2'); } .x { background: url(` + server.URL + `/css/parts/missing.png); }`
+	if string(part) != expected {
+		t.Errorf("converted a.css:\n%s\nwant:\n%s", part, expected)
+	}
+}
+
+func TestMirrorWebsiteBaseAndRefresh(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":                `<html><head><meta http-equiv="refresh" content="0; url=/docs/page.html"></head></html>`,
+		"/docs/page.html":  `<html><head><base href="/assets/"></head><body><img src="logo.png"><a href="/">Home</a></body></html>`,
+		"/assets/logo.png": "PNG",
+		"/docs/logo.png":   "wrong base",
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, ConvertLinks: true})
+	if err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	if _, err := os.Stat(filepath.Join(host.Host, "assets", "logo.png")); err != nil {
+		t.Errorf("logo.png should be resolved against <base href>: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(host.Host, "docs", "logo.png")); !os.IsNotExist(err) {
+		t.Errorf("docs/logo.png should not be downloaded")
+	}
+
+	page, err := os.ReadFile(filepath.Join(host.Host, "docs", "page.html"))
+	if err != nil {
+		t.Fatalf("the refresh target was not mirrored: %v", err)
+	}
+	expected := `<html><head><base href=""></head><body><img src="../assets/logo.png"><a href="../index.html">Home</a></body></html>`
+	if string(page) != expected {
+		t.Errorf("converted page:\n%s\nwant:\n%s", page, expected)
+	}
+
+	index, err := os.ReadFile(filepath.Join(host.Host, "index.html"))
+	if err != nil {
+		t.Fatalf("start page was not saved: %v", err)
+	}
+	if !strings.Contains(string(index), `content="0; url=docs/page.html"`) {
+		t.Errorf("refresh target was not converted: %s", index)
+	}
+}
@@ -4,10 +4,11 @@ package utils
 import (
 	"bufio"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 // function to read urls from a file
@@ -34,61 +35,50 @@ func ReadUrlsFromFile(filePath string) ([]string, error) {
 	return urls, nil
 }
 
-func DownloadFilesConcurrently(urls []string, outputPrefix string, background bool, rateLimit int64, path string) error {
-	var wg sync.WaitGroup
+// DefaultJobs is the number of downloads -i runs at once when --jobs is not given.
+const DefaultJobs = 4
+
+// DownloadFilesConcurrently downloads every URL with a pool of jobs workers. Once
+// limits are reached no new download starts; the batch ends with those running.
+func DownloadFilesConcurrently(urls []string, outputPrefix string, background bool, rateLimit int64, path string, jobs int, limits Limits) error {
 	errorChan := make(chan error, len(urls))
+	workers := workerCount(jobs, len(urls))
+	budget := newDownloadBudget(limits)
 
-	// If rate limit is specified, divide it among concurrent downloads
-	var perFileRateLimit int64
-	if rateLimit > 0 {
-		perFileRateLimit = rateLimit / int64(len(urls))
-		fmt.Printf("Rate limit per file: %.2f KB/s\n", float64(perFileRateLimit)/1024)
-	}
+	limiter := batchLimiter(rateLimit)
 
-	// Print total content size
-	sizes := make([]int64, len(urls))
-	for i, url := range urls {
-		resp, err := http.Get(url)
-		if err != nil {
-			return fmt.Errorf("error getting content size: %v", err)
+	var skipped atomic.Int32
+	runWorkers(len(urls), workers, func(index int) {
+		url := urls[index]
+		if !budget.take() {
+			skipped.Add(1)
+			return
 		}
-		sizes[i] = resp.ContentLength
-		resp.Body.Close()
-	}
-	fmt.Printf("Content size: %v\n", sizes)
 
-	for i, url := range urls {
-		wg.Add(1)
-		go func(url string, index int) {
-			defer wg.Done()
-
-			var filename string
-			if outputPrefix != "" {
-				filename = fmt.Sprintf("%s_%d", outputPrefix, index)
-			} else {
-				filename = GetFileName(url)
-			}
+		var filename string
+		if outputPrefix != "" {
+			filename = fmt.Sprintf("%s_%d", outputPrefix, index)
+		} else {
+			filename = GetFileName(url)
+		}
 
-			// Combine path with filename if path is specified
-			if path != "" {
-				filename = filepath.Join(path, filename)
-			}
+		// Combine path with filename if path is specified
+		if path != "" {
+			filename = filepath.Join(path, filename)
+		}
 
-			err := DownloadFile(url, filename, background, perFileRateLimit)
-			if err != nil {
-				errorChan <- fmt.Errorf("error downloading %s: %v", url, err)
-				return
-			}
-			fmt.Printf("Finished %s\n", filename)
-		}(url, i)
+		err := downloadWithBudget(url, filename, background, limiter, budget)
+		if err != nil {
+			errorChan <- fmt.Errorf("error downloading %s: %v", url, err)
+			return
+		}
+		fmt.Printf("Finished %s\n", filename)
+	})
+	close(errorChan)
+	if summary := budget.summary(); summary != "" {
+		fmt.Printf("\nStopped early, %s: %d of %d files not downloaded\n", summary, skipped.Load(), len(urls))
 	}
 
-	// Wait for all downloads to complete
-	go func() {
-		wg.Wait()
-		close(errorChan)
-	}()
-
 	// Check for any errors
 	var errCount int
 	for err := range errorChan {
@@ -103,3 +93,137 @@ func DownloadFilesConcurrently(urls []string, outputPrefix string, background bo
 	fmt.Printf("\nDownload finished: %v\n", urls)
 	return nil
 }
+
+// DownloadFilesConcatenated downloads every URL concurrently into a temporary part file
+// and then appends the parts to a single output file in the order the URLs were given.
+// Useful for split archives served as separate URLs.
+// The limits apply as for DownloadFilesConcurrently, but a batch they stop is not
+// assembled since parts would be missing.
+func DownloadFilesConcatenated(urls []string, output string, background bool, rateLimit int64, path string, jobs int, limits Limits) error {
+	if path != "" {
+		output = filepath.Join(path, output)
+	}
+	workers := workerCount(jobs, len(urls))
+	budget := newDownloadBudget(limits)
+
+	limiter := batchLimiter(rateLimit)
+
+	// Keep the parts next to the output so the final assembly never crosses filesystems
+	parts := make([]string, len(urls))
+	for i := range urls {
+		part, err := os.CreateTemp(filepath.Dir(output), ".wget-part-*")
+		if err != nil {
+			removeFiles(parts)
+			return fmt.Errorf("error creating part file: %v", err)
+		}
+		part.Close()
+		parts[i] = part.Name()
+	}
+	defer removeFiles(parts)
+
+	errorChan := make(chan error, len(urls))
+
+	runWorkers(len(urls), workers, func(index int) {
+		if !budget.take() {
+			return
+		}
+		err := downloadWithBudget(urls[index], parts[index], background, limiter, budget)
+		if err != nil {
+			errorChan <- fmt.Errorf("error downloading %s: %v", urls[index], err)
+		}
+	})
+	close(errorChan)
+
+	// Any missing part would corrupt the joined file, so refuse to assemble it
+	var errCount int
+	for err := range errorChan {
+		errCount++
+		fmt.Println(err)
+	}
+	if errCount > 0 {
+		return fmt.Errorf("%d downloads failed, %s not written", errCount, output)
+	}
+	if summary := budget.summary(); summary != "" {
+		return fmt.Errorf("stopped early, %s: %s not written", summary, output)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	defer out.Close()
+
+	for i, part := range parts {
+		if err := appendFile(out, part); err != nil {
+			return fmt.Errorf("error appending %s: %v", urls[i], err)
+		}
+	}
+
+	fmt.Printf("\nConcatenated %d files into %s\n", len(urls), output)
+	return nil
+}
+
+// appendFile copies the contents of the file at path to the end of out
+func appendFile(out io.Writer, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// removeFiles deletes the named files, ignoring empty names and errors
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		if path != "" {
+			os.Remove(path)
+		}
+	}
+}
+
+// batchLimiter returns the limiter of an -i batch. Every download draws from one
+// bucket, so the total stays at the limit however many of them are still running.
+func batchLimiter(rateLimit int64) *RateLimiter {
+	limiter := NewRateLimiter(rateLimit)
+	if limiter != nil {
+		fmt.Printf("Rate limit shared by all downloads: %.2f KB/s\n", float64(rateLimit)/1024)
+	}
+	return limiter
+}
+
+// workerCount caps the requested number of jobs to the amount of work available
+func workerCount(jobs, count int) int {
+	if jobs <= 0 {
+		jobs = DefaultJobs
+	}
+	if count < jobs {
+		return count
+	}
+	return jobs
+}
+
+// runWorkers calls work for every index in [0, count) using a fixed pool of workers
+// that pull from a shared queue, and returns once all of them have finished.
+func runWorkers(count, workers int, work func(index int)) {
+	queue := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				work(index)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
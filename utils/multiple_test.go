@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -66,7 +67,7 @@ func TestDownloadFilesConcurrently(t *testing.T) {
 	outputPrefix := "test_file"
 	rateLimit := int64(1024) // Set to 1KB/s for testing rate limiting
 
-	err = DownloadFilesConcurrently(urls, outputPrefix, false, rateLimit, outputDir)
+	err = DownloadFilesConcurrently(urls, outputPrefix, false, rateLimit, outputDir, DefaultJobs, Limits{})
 	if err != nil {
 		t.Fatalf("DownloadFilesConcurrently failed: %v", err)
 	}
@@ -121,7 +122,7 @@ func TestConcurrentDownloadWithRateLimit(t *testing.T) {
 	defer os.RemoveAll(outputDir)
 
 	// Test the DownloadFilesConcurrently function with rate limit
-	err = DownloadFilesConcurrently(urls, "rate_test_file", false, rateLimit, outputDir)
+	err = DownloadFilesConcurrently(urls, "rate_test_file", false, rateLimit, outputDir, DefaultJobs, Limits{})
 	if err != nil {
 		t.Fatalf("DownloadFilesConcurrently failed under rate limiting: %v", err)
 	}
@@ -143,3 +144,106 @@ func TestConcurrentDownloadWithRateLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestDownloadFilesConcatenated(t *testing.T) {
+	// Serve each part with a delay that makes later parts finish first
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/part1":
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("first-"))
+		case "/part2":
+			w.Write([]byte("second-"))
+		case "/part3":
+			w.Write([]byte("third"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	outputDir, err := os.MkdirTemp("", "concat")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	urls := []string{server.URL + "/part1", server.URL + "/part2", server.URL + "/part3"}
+	err = DownloadFilesConcatenated(urls, "joined.bin", true, 0, outputDir, 2, Limits{})
+	if err != nil {
+		t.Fatalf("DownloadFilesConcatenated failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "joined.bin"))
+	if err != nil {
+		t.Fatalf("could not read joined file: %v", err)
+	}
+	if string(content) != "first-second-third" {
+		t.Errorf("joined content = %q; want %q", content, "first-second-third")
+	}
+
+	// Part files must not be left behind
+	entries, _ := os.ReadDir(outputDir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the joined file in %s, found %d entries", outputDir, len(entries))
+	}
+
+	// A failing part must not produce a truncated output
+	urls = append(urls, server.URL+"/missing")
+	err = DownloadFilesConcatenated(urls, "broken.bin", true, 0, outputDir, 2, Limits{})
+	if err == nil {
+		t.Errorf("expected an error when a part fails to download")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "broken.bin")); !os.IsNotExist(err) {
+		t.Errorf("output file should not exist after a failed part")
+	}
+}
+
+func TestRunWorkersBoundsConcurrency(t *testing.T) {
+	const workers = 3
+	var mu sync.Mutex
+	active, peak := 0, 0
+	done := make([]bool, 20)
+
+	runWorkers(len(done), workers, func(index int) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		done[index] = true
+		mu.Unlock()
+	})
+
+	if peak > workers {
+		t.Errorf("peak concurrency = %d; want at most %d", peak, workers)
+	}
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("job %d was never run", i)
+		}
+	}
+}
+
+func TestWorkerCount(t *testing.T) {
+	tests := []struct {
+		jobs, count, expected int
+	}{
+		{4, 100, 4},
+		{4, 2, 2},
+		{0, 100, DefaultJobs},
+		{10, 10, 10},
+	}
+
+	for _, tt := range tests {
+		if got := workerCount(tt.jobs, tt.count); got != tt.expected {
+			t.Errorf("workerCount(%d, %d) = %d; want %d", tt.jobs, tt.count, got, tt.expected)
+		}
+	}
+}
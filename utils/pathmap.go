@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// fileNameRules says which characters may appear in the names of saved files, as
+// chosen with --restrict-file-names
+type fileNameRules struct {
+	windows   bool // also escape \ : * ? " < > | and avoid reserved device names
+	ascii     bool // escape every byte outside printable ASCII
+	lowercase bool
+}
+
+// windowsReserved are the device names Windows refuses as file names, with or without
+// an extension
+var windowsReserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// parseRestrictFileNames reads a comma-separated list of unix, windows, ascii and
+// lowercase. An empty value picks the rules of the running system.
+func parseRestrictFileNames(value string) (fileNameRules, error) {
+	var rules fileNameRules
+	if value == "" {
+		rules.windows = runtime.GOOS == "windows"
+		return rules, nil
+	}
+	for _, mode := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(mode)) {
+		case "unix":
+			rules.windows = false
+		case "windows":
+			rules.windows = true
+		case "ascii":
+			rules.ascii = true
+		case "lowercase":
+			rules.lowercase = true
+		default:
+			return rules, fmt.Errorf("invalid --restrict-file-names mode %q, expected unix, windows, ascii or lowercase", mode)
+		}
+	}
+	return rules, nil
+}
+
+// urlToPath maps the path and query of u to a slash-separated file path. Percent
+// escapes are decoded, dot segments are resolved without ever climbing above the
+// top, a directory URL gets index.html, and so does an extensionless HTML page, which
+// becomes a directory. The query is kept in the file name so /page?id=1 and
+// /page?id=2 do not collide. Each name is then escaped according to rules.
+func urlToPath(u *url.URL, isHTML bool, rules fileNameRules) string {
+	var segments []string
+	rawSegments := strings.Split(u.EscapedPath(), "/")
+	for _, raw := range rawSegments {
+		segment, err := url.PathUnescape(raw)
+		if err != nil {
+			segment = raw
+		}
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	// A path ending in a directory, including a dot segment, names its index
+	last := rawSegments[len(rawSegments)-1]
+	if len(segments) == 0 || last == "" || last == "." || last == ".." {
+		segments = append(segments, "index.html")
+	} else if name := segments[len(segments)-1]; isHTML && !strings.Contains(name, ".") {
+		segments = append(segments, "index.html")
+	}
+
+	if u.RawQuery != "" {
+		separator := "?"
+		if rules.windows {
+			separator = "@"
+		}
+		segments[len(segments)-1] += separator + u.RawQuery
+	}
+
+	for i, segment := range segments {
+		segments[i] = escapeFileName(segment, rules)
+	}
+	return path.Join(segments...)
+}
+
+// escapeFileName percent-escapes the bytes of name that rules do not allow in a file
+// name. A slash is always escaped, so a name can never add a directory level.
+func escapeFileName(name string, rules fileNameRules) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		escape := c < 0x20 || c == 0x7f || c == '/'
+		if rules.windows && strings.IndexByte(`\:*?"<>|`, c) >= 0 {
+			escape = true
+		}
+		if rules.ascii && c >= 0x80 {
+			escape = true
+		}
+		if escape {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	name = b.String()
+
+	if rules.lowercase {
+		name = strings.ToLower(name)
+	}
+	if rules.windows {
+		// Windows drops trailing dots and spaces and refuses device names
+		if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+			name += "_"
+		}
+		stem, _, _ := strings.Cut(name, ".")
+		if windowsReserved[strings.ToLower(stem)] {
+			name = "_" + name
+		}
+	}
+	return name
+}
+
+// escapeLocalPath turns a slash-separated file path into a relative URL that names
+// the same file, so characters such as ? and % in saved names survive in links
+func escapeLocalPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	// A colon in the first segment would make it read as a URL scheme
+	segments[0] = strings.ReplaceAll(segments[0], ":", "%3A")
+	return strings.Join(segments, "/")
+}
+
+// insideRoot checks that a slash-separated path relative to the mirror root cannot
+// leave it, whatever the URL it came from looked like
+func insideRoot(relativePath string) error {
+	if !filepath.IsLocal(filepath.FromSlash(relativePath)) {
+		return fmt.Errorf("refusing to write outside the mirror root: %s", relativePath)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestURLToPath(t *testing.T) {
+	unix := fileNameRules{}
+	tests := []struct {
+		name     string
+		url      string
+		isHTML   bool
+		rules    fileNameRules
+		expected string
+	}{
+		{"root", "http://example.com", false, unix, "index.html"},
+		{"directory", "http://example.com/docs/", false, unix, "docs/index.html"},
+		{"file", "http://example.com/docs/a.pdf", false, unix, "docs/a.pdf"},
+		{"extensionless page", "http://example.com/about", true, unix, "about/index.html"},
+		{"extensionless file", "http://example.com/LICENSE", false, unix, "LICENSE"},
+		{"query", "http://example.com/page.php?id=1", true, unix, "page.php?id=1"},
+		{"query on extensionless page", "http://example.com/list?page=2", true, unix, "list/index.html?page=2"},
+		{"query on directory", "http://example.com/?lang=fr", true, unix, "index.html?lang=fr"},
+		{"decoded spaces", "http://example.com/my%20file.txt", false, unix, "my file.txt"},
+		{"encoded slash stays in the name", "http://example.com/a%2Fb.txt", false, unix, "a%2Fb.txt"},
+		{"dot segments", "http://example.com/a/./b/../c.txt", false, unix, "a/c.txt"},
+		{"cannot climb above the top", "http://example.com/../../etc/passwd", false, unix, "etc/passwd"},
+		{"encoded dot segments", "http://example.com/%2e%2e/%2E%2E/secret", false, unix, "secret"},
+		{"trailing dot segment", "http://example.com/a/b/..", false, unix, "a/index.html"},
+		{"control characters", "http://example.com/a%0Ab.txt", false, unix, "a%0Ab.txt"},
+		{"windows characters", "http://example.com/a:b*c.txt?x=<1>", false, fileNameRules{windows: true}, "a%3Ab%2Ac.txt@x=%3C1%3E"},
+		{"windows reserved name", "http://example.com/con.txt", false, fileNameRules{windows: true}, "_con.txt"},
+		{"windows trailing dot", "http://example.com/name.", false, fileNameRules{windows: true}, "name._"},
+		{"ascii", "http://example.com/caf%C3%A9.html", false, fileNameRules{ascii: true}, "caf%C3%A9.html"},
+		{"unicode kept", "http://example.com/caf%C3%A9.html", false, unix, "café.html"},
+		{"lowercase", "http://example.com/Docs/README.TXT", false, fileNameRules{lowercase: true}, "docs/readme.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("invalid test URL: %v", err)
+			}
+			if got := urlToPath(u, tt.isHTML, tt.rules); got != tt.expected {
+				t.Errorf("urlToPath(%q) = %q; want %q", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRestrictFileNames(t *testing.T) {
+	rules, err := parseRestrictFileNames("windows, lowercase")
+	if err != nil {
+		t.Fatalf("parseRestrictFileNames failed: %v", err)
+	}
+	if !rules.windows || !rules.lowercase || rules.ascii {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+	if _, err := parseRestrictFileNames("dos"); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
+
+func TestInsideRoot(t *testing.T) {
+	for _, p := range []string{"../x", "/etc/passwd", "a/../../x", ""} {
+		if err := insideRoot(p); err == nil {
+			t.Errorf("insideRoot(%q) should fail", p)
+		}
+	}
+	if err := insideRoot("example.com/a/b.html"); err != nil {
+		t.Errorf("insideRoot failed for a local path: %v", err)
+	}
+}
+
+func TestEscapeLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"page.php?id=1":      "page.php%3Fid=1",
+		"../a%2Fb.txt":       "../a%252Fb.txt",
+		"127.0.0.1:8080/a":   "127.0.0.1%3A8080/a",
+		"../host:8080/a.css": "../host:8080/a.css",
+	}
+	for in, expected := range tests {
+		if got := escapeLocalPath(in); got != expected {
+			t.Errorf("escapeLocalPath(%q) = %q; want %q", in, got, expected)
+		}
+	}
+}
+
+func TestMirrorWebsiteQueryStrings(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":           `<html><body><a href="/list?page=1">1</a> <a href="/list?page=2">2</a> <a href="/../../escape.txt">x</a></body></html>`,
+		"/list":       `<html><body>list</body></html>`,
+		"/escape.txt": "TXT",
+	})
+	defer server.Close()
+	dir := chdirTemp(t)
+
+	if err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, ConvertLinks: true}); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	for _, want := range []string{"list/index.html?page=1", "list/index.html?page=2", "escape.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, host.Host, want)); err != nil {
+			t.Errorf("expected %s to be mirrored: %v", want, err)
+		}
+	}
+
+	index, err := os.ReadFile(filepath.Join(dir, host.Host, "index.html"))
+	if err != nil {
+		t.Fatalf("start page was not saved: %v", err)
+	}
+	if !strings.Contains(string(index), `href="list/index.html%3Fpage=2"`) {
+		t.Errorf("query links were not converted to the local files: %s", index)
+	}
+}
+
+func TestLocalPathLayout(t *testing.T) {
+	u, _ := url.Parse("https://example.com/pub/docs/v1/a.html")
+	tests := []struct {
+		name     string
+		opts     MirrorOptions
+		expected string
+	}{
+		{"default", MirrorOptions{}, "example.com/pub/docs/v1/a.html"},
+		{"no directories", MirrorOptions{NoDirectories: true}, "a.html"},
+		{"no host directories", MirrorOptions{NoHostDirectories: true}, "pub/docs/v1/a.html"},
+		{"cut dirs", MirrorOptions{CutDirs: 2}, "example.com/v1/a.html"},
+		{"cut more dirs than there are", MirrorOptions{CutDirs: 5, NoHostDirectories: true}, "a.html"},
+		{"protocol directories", MirrorOptions{ProtocolDirectories: true}, "https/example.com/pub/docs/v1/a.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mirrorer{opts: tt.opts}
+			if got := m.localPath(u, htmlContent); got != tt.expected {
+				t.Errorf("localPath = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClaimPath(t *testing.T) {
+	m := &mirrorer{paths: make(map[string]string)}
+	claims := []struct {
+		url      string
+		path     string
+		expected string
+	}{
+		{"http://a.com/", "index.html", "index.html"},
+		{"http://b.com/", "index.html", "index.1.html"},
+		{"http://a.com/", "index.html", "index.html"},
+		{"http://c.com/", "index.html", "index.2.html"},
+		{"http://a.com/LICENSE", "LICENSE", "LICENSE"},
+		{"http://b.com/LICENSE", "LICENSE", "LICENSE.1"},
+	}
+	for _, c := range claims {
+		if got := m.claimPath(c.url, c.path); got != c.expected {
+			t.Errorf("claimPath(%q, %q) = %q; want %q", c.url, c.path, got, c.expected)
+		}
+	}
+}
+
+func TestMirrorWebsiteFlatWithPrefix(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":              `<html><body><a href="/docs/">Docs</a><img src="/img/logo.png"></body></html>`,
+		"/docs/":         `<html><body><a href="/">Home</a><img src="/docs/logo.png"></body></html>`,
+		"/img/logo.png":  "ROOT LOGO",
+		"/docs/logo.png": "DOCS LOGO",
+	})
+	defer server.Close()
+	dir := chdirTemp(t)
+
+	opts := MirrorOptions{Recursive: true, ConvertLinks: true, NoDirectories: true, DirectoryPrefix: "out"}
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatalf("prefix folder was not created: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			t.Errorf("-nd should not create folders, found %s", entry.Name())
+		}
+		if strings.HasPrefix(entry.Name(), ".wget-mirror-") {
+			continue // the mirror state of the host
+		}
+		names = append(names, entry.Name())
+	}
+	if len(names) != 4 {
+		t.Errorf("expected 2 pages and 2 logos, found %v", names)
+	}
+
+	docs, err := os.ReadFile(filepath.Join(dir, "out", "index.1.html"))
+	if err != nil {
+		t.Fatalf("second index page was not saved under a numbered name: %v", err)
+	}
+	docsLogo := "logo.png"
+	if data, _ := os.ReadFile(filepath.Join(dir, "out", docsLogo)); string(data) != "DOCS LOGO" {
+		docsLogo = "logo.1.png"
+	}
+	if !strings.Contains(string(docs), `href="index.html"`) || !strings.Contains(string(docs), `src="`+docsLogo+`"`) {
+		t.Errorf("links were not converted to the flat names: %s", docs)
+	}
+}
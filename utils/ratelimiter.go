@@ -5,6 +5,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,6 +16,81 @@ type RateLimitReader struct {
 	lastRead   time.Time
 	readBytes  int64
 	bucketSize int64
+	limiter    *RateLimiter // shared bucket, used instead of bucketSize when set
+}
+
+// RateLimiter is a token bucket that can be shared by any number of readers.
+// Its rate is the combined throughput of everything drawing from it, so the
+// total stays at the configured rate however many transfers are active.
+type RateLimiter struct {
+	mu       sync.Mutex
+	rate     int64 // bytes per second
+	tokens   float64
+	lastFill time.Time
+}
+
+// NewRateLimiter creates a limiter for rate bytes per second. A rate of zero or
+// less means no limit and returns nil, which readers treat as unlimited.
+func NewRateLimiter(rate int64) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:     rate,
+		tokens:   float64(rate), // allow up to one second of burst
+		lastFill: time.Now(),
+	}
+}
+
+// Rate returns the configured limit in bytes per second
+func (l *RateLimiter) Rate() int64 {
+	return l.rate
+}
+
+// take blocks until at least one byte is available and returns how many of the
+// requested n bytes the caller may read.
+func (l *RateLimiter) take(n int) int {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.lastFill).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+		l.lastFill = now
+
+		if l.tokens >= 1 {
+			granted := n
+			if float64(granted) > l.tokens {
+				granted = int(l.tokens)
+			}
+			l.tokens -= float64(granted)
+			l.mu.Unlock()
+			return granted
+		}
+
+		// Sleep roughly until the next byte becomes available
+		wait := time.Duration((1 - l.tokens) / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		time.Sleep(wait)
+	}
+}
+
+// refund returns bytes that were granted by take but never read, never filling the
+// bucket past its one second of burst
+func (l *RateLimiter) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.tokens += float64(n)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.mu.Unlock()
 }
 
 // parseRateLimit converts rate which is a string (like "400k" or "2M") to bytes per second
@@ -53,8 +129,25 @@ func NewRateLimitReader(reader io.Reader, rateLimit int64) *RateLimitReader {
 	}
 }
 
+// NewSharedRateLimitReader creates a reader that draws from limiter, which may be
+// shared with other readers. A nil limiter reads without any limit.
+func NewSharedRateLimitReader(reader io.Reader, limiter *RateLimiter) *RateLimitReader {
+	r := &RateLimitReader{
+		reader:   reader,
+		lastRead: time.Now(),
+		limiter:  limiter,
+	}
+	if limiter != nil {
+		r.rateLimit = limiter.Rate()
+	}
+	return r
+}
+
 // Read is our custom io.Reader with rate limiting 
 func (r *RateLimitReader) Read(p []byte) (int, error) {
+	if r.limiter != nil {
+		return r.readShared(p)
+	}
 	if r.rateLimit <= 0 {
 		return r.reader.Read(p)
 	}
@@ -87,4 +180,21 @@ func (r *RateLimitReader) Read(p []byte) (int, error) {
 	}
 
 	return n, err
-}
\ No newline at end of file
+}
+
+// readShared limits the read to the bytes granted by the shared limiter
+func (r *RateLimitReader) readShared(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	granted := r.limiter.take(len(p))
+	n, err := r.reader.Read(p[:granted])
+	r.limiter.refund(granted - n)
+	if n > 0 {
+		r.readBytes += int64(n)
+		r.lastRead = time.Now()
+	}
+
+	return n, err
+}
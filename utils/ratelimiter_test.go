@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"time"
 )
 
 // TestParseRateLimit tests the ParseRateLimit function
@@ -109,3 +110,68 @@ func TestRateLimitReaderRead(t *testing.T) {
 		}
 	})
 }
+
+// TestNewRateLimiter tests that a non-positive rate disables limiting
+func TestNewRateLimiter(t *testing.T) {
+	if NewRateLimiter(0) != nil {
+		t.Error("NewRateLimiter(0) should return nil")
+	}
+	limiter := NewRateLimiter(2048)
+	if limiter == nil || limiter.Rate() != 2048 {
+		t.Errorf("NewRateLimiter(2048) returned %+v", limiter)
+	}
+}
+
+// TestSharedRateLimitReader tests that readers sharing a limiter split one bucket
+func TestSharedRateLimitReader(t *testing.T) {
+	limiter := NewRateLimiter(100) // 100B/s shared
+	first := NewSharedRateLimitReader(bytes.NewReader(bytes.Repeat([]byte("a"), 500)), limiter)
+	second := NewSharedRateLimitReader(bytes.NewReader(bytes.Repeat([]byte("b"), 500)), limiter)
+
+	buf := make([]byte, 500)
+	n, err := first.Read(buf)
+	if err != nil {
+		t.Fatalf("Read returned an error: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("first Read = %d; want the full 100 byte bucket", n)
+	}
+
+	// The bucket is now empty, so the second reader has to wait for a refill
+	start := time.Now()
+	n, err = second.Read(buf)
+	if err != nil {
+		t.Fatalf("Read returned an error: %v", err)
+	}
+	if n < 1 || n > 10 {
+		t.Errorf("second Read = %d; want a handful of refilled bytes", n)
+	}
+	if time.Since(start) < 5*time.Millisecond {
+		t.Errorf("second Read did not wait for the shared bucket to refill")
+	}
+}
+
+// TestSharedRateLimitReaderRefund tests that bytes granted but not read go back to the bucket
+func TestSharedRateLimitReaderRefund(t *testing.T) {
+	limiter := NewRateLimiter(100)
+	short := NewSharedRateLimitReader(bytes.NewReader([]byte("hi")), limiter)
+
+	buf := make([]byte, 100)
+	if n, _ := short.Read(buf); n != 2 {
+		t.Fatalf("Read = %d; want 2", n)
+	}
+
+	other := NewSharedRateLimitReader(bytes.NewReader(bytes.Repeat([]byte("x"), 200)), limiter)
+	if n, _ := other.Read(buf); n < 98 {
+		t.Errorf("Read after refund = %d; want at least 98", n)
+	}
+}
+
+// TestRateLimiterRefundCapped tests that a refund never lets more than a second of burst through
+func TestRateLimiterRefundCapped(t *testing.T) {
+	limiter := NewRateLimiter(100)
+	limiter.refund(500)
+	if granted := limiter.take(1000); granted > 100 {
+		t.Errorf("take after a large refund = %d; want at most the 100 byte bucket", granted)
+	}
+}
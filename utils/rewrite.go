@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+)
+
+// rewriteLinks returns a copy of data in which every link that replace accepts is
+// swapped for the returned value at the link's recorded offsets. Everything else in
+// the document, including the original quoting, is copied byte for byte. links must
+// be in document order, as returned by extractLinks.
+func rewriteLinks(data []byte, links []htmlLink, replace func(link htmlLink) (string, bool)) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data))
+	last := 0
+
+	for _, link := range links {
+		if link.Start < last || link.End > len(data) {
+			continue // overlapping or stale offsets, leave the text alone
+		}
+		value, ok := replace(link)
+		if !ok {
+			continue
+		}
+		out.Write(data[last:link.Start])
+		out.WriteString(encodeLinkValue(value, link))
+		last = link.End
+	}
+
+	out.Write(data[last:])
+	return out.Bytes()
+}
+
+// encodeLinkValue escapes value so it can stand where link was found without
+// changing the surrounding syntax
+func encodeLinkValue(value string, link htmlLink) string {
+	// Unquoted values end at whitespace, and CSS url() also ends at quotes and parentheses
+	value = strings.NewReplacer(" ", "%20", "\t", "%09", "\n", "%0A").Replace(value)
+	if link.Attr == "" || link.Attr == "style" {
+		value = strings.NewReplacer("(", "%28", ")", "%29", "'", "%27", `"`, "%22").Replace(value)
+	}
+	if srcsetAttrs[link.Attr] {
+		// A comma would end the candidate early
+		value = strings.ReplaceAll(value, ",", "%2C")
+	}
+	if link.Attr == "" {
+		// Inside a <style> block character references are not decoded
+		return value
+	}
+
+	value = strings.ReplaceAll(value, "&", "&amp;")
+	switch link.Quote {
+	case '"':
+		value = strings.ReplaceAll(value, `"`, "&quot;")
+	case '\'':
+		value = strings.ReplaceAll(value, "'", "&#39;")
+	default:
+		value = strings.NewReplacer(`"`, "&quot;", "'", "&#39;", ">", "&gt;", "`", "&#96;").Replace(value)
+	}
+	return value
+}
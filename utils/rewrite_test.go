@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"testing"
+)
+
+// mapLinks returns a localLink function backed by a map of absolute URL to local path
+func mapLinks(local map[string]string) func(string) (string, bool) {
+	return func(absoluteURL string) (string, bool) {
+		path, ok := local[absoluteURL]
+		return path, ok
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	doc := `<html><body>
+<p>Text mentioning src="/a.png" stays as it is</p>
+<img src="/a.png"><img src='/a.png.bak'><img src=/a.png alt=x>
+<a href="/page.html?x=1&amp;y=2">Q</a>
+<div style="background:url(/a.png)"></div>
+</body></html>`
+
+	local := mapLinks(map[string]string{
+		"http://example.com/a.png":             "img/a.png",
+		"http://example.com/a.png.bak":         "img/a.png.bak",
+		"http://example.com/page.html?x=1&y=2": "page x&y.html",
+	})
+
+	got := string(updateLinks([]byte(doc), "http://example.com/", extractLinks([]byte(doc)), local))
+	expected := `<html><body>
+<p>Text mentioning src="/a.png" stays as it is</p>
+<img src="img/a.png"><img src='img/a.png.bak'><img src=img/a.png alt=x>
+<a href="page%20x&amp;y.html">Q</a>
+<div style="background:url(img/a.png)"></div>
+</body></html>`
+
+	if got != expected {
+		t.Errorf("rewritten document:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestRewriteLinksUnchanged(t *testing.T) {
+	doc := "<html>\r\n<BODY  class = 'x' ><IMG SRC = \"/b.gif\" ></BODY></html>"
+	links := extractLinks([]byte(doc))
+
+	got := rewriteLinks([]byte(doc), links, func(link htmlLink) (string, bool) {
+		return "", false
+	})
+	if string(got) != doc {
+		t.Errorf("document changed without any replacement:\n%q\nwant:\n%q", got, doc)
+	}
+}
+
+func TestUpdateLinksMakesMissingTargetsAbsolute(t *testing.T) {
+	doc := `<a href="../other/">Other</a><a href="guide.html#intro">Guide</a><a href="https://elsewhere.org/">X</a>`
+	local := mapLinks(map[string]string{
+		"http://example.com/docs/guide.html": "guide.html",
+	})
+
+	got := string(updateLinks([]byte(doc), "http://example.com/docs/index.html", extractLinks([]byte(doc)), local))
+	expected := `<a href="http://example.com/other/">Other</a><a href="guide.html#intro">Guide</a><a href="https://elsewhere.org/">X</a>`
+	if got != expected {
+		t.Errorf("updateLinks = %s; want %s", got, expected)
+	}
+}
+
+func TestUpdateCSSJSPathsOnlyTouchesScriptsAndStylesheets(t *testing.T) {
+	doc := `<link rel="stylesheet" href="/s.css"><script src="/app.js"></script><img src="/app.js">`
+	local := mapLinks(map[string]string{
+		"http://example.com/s.css":  "s.css",
+		"http://example.com/app.js": "app.js",
+	})
+
+	got := string(updateCSSJSPaths([]byte(doc), "http://example.com/", extractLinks([]byte(doc)), local))
+	expected := `<link rel="stylesheet" href="s.css"><script src="app.js"></script><img src="/app.js">`
+	if got != expected {
+		t.Errorf("updateCSSJSPaths = %s; want %s", got, expected)
+	}
+}
+
+func TestRelativeLink(t *testing.T) {
+	tests := []struct {
+		from, to, expected string
+	}{
+		{"index.html", "css/site.css", "css/site.css"},
+		{"docs/guide/index.html", "css/site.css", "../../css/site.css"},
+		{"docs/a.html", "docs/b.html", "b.html"},
+		{"docs/a.html", "docs/img/x.png", "img/x.png"},
+	}
+
+	for _, tt := range tests {
+		if got := relativeLink(tt.from, tt.to); got != tt.expected {
+			t.Errorf("relativeLink(%q, %q) = %q; want %q", tt.from, tt.to, got, tt.expected)
+		}
+	}
+}
+
+func TestUpdateLinksSrcset(t *testing.T) {
+	doc := `<img src="/a.png" srcset="/a.png 1x, /a@2x.png 2x, /missing.png 3x">`
+	local := mapLinks(map[string]string{
+		"http://example.com/a.png":    "a.png",
+		"http://example.com/a@2x.png": "img,2x/a.png",
+	})
+
+	got := string(updateLinks([]byte(doc), "http://example.com/", extractLinks([]byte(doc)), local))
+	expected := `<img src="a.png" srcset="a.png 1x, img%2C2x/a.png 2x, http://example.com/missing.png 3x">`
+	if got != expected {
+		t.Errorf("rewritten document:\n%s\nwant:\n%s", got, expected)
+	}
+}
@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsAgent is the product token matched against User-agent lines in robots.txt
+const robotsAgent = "wget"
+
+// newCrawlRequest builds a request of a mirror or spider crawl. It is sent as
+// robotsAgent, so the server sees the agent whose robots.txt rules are followed.
+func newCrawlRequest(method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", robotsAgent)
+	return req, nil
+}
+
+// maxRobotsSize caps how much of a robots.txt file is read, as RFC 9309 allows
+const maxRobotsSize = 500 * 1024
+
+// robotsRules is the part of one host's robots.txt that applies to this crawler.
+// A nil *robotsRules allows everything.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+// robotsRule is a single Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsGroup is a set of rules and the user agents they were written for
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// parseRobots reads a robots.txt file and keeps the rules of the groups naming agent,
+// falling back to the "*" groups when none does. Sitemap lines are kept whatever
+// group they appear in.
+func parseRobots(data []byte, agent string) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	var sitemaps []string
+	inAgents := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share the rules that follow them
+			if !inAgents {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, newRobotsRule(key == "allow", value))
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		}
+	}
+
+	rules := &robotsRules{sitemaps: sitemaps}
+	matched := selectRobotsGroups(groups, strings.ToLower(agent))
+	if len(matched) == 0 {
+		matched = selectRobotsGroups(groups, "*")
+	}
+	for _, group := range matched {
+		rules.rules = append(rules.rules, group.rules...)
+		if group.crawlDelay > rules.crawlDelay {
+			rules.crawlDelay = group.crawlDelay
+		}
+	}
+	return rules
+}
+
+// selectRobotsGroups returns every group naming agent. Groups for the same agent
+// are merged, as RFC 9309 asks.
+func selectRobotsGroups(groups []*robotsGroup, agent string) []*robotsGroup {
+	var matched []*robotsGroup
+	for _, group := range groups {
+		for _, a := range group.agents {
+			if a == agent {
+				matched = append(matched, group)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// newRobotsRule compiles a path pattern where "*" matches any run of characters and
+// a trailing "$" anchors the end of the path
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	anchored := strings.HasSuffix(pattern, "$")
+	expr := regexp.QuoteMeta(strings.TrimSuffix(pattern, "$"))
+	expr = "^" + strings.ReplaceAll(expr, `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return robotsRule{allow: allow, pattern: pattern, re: regexp.MustCompile(expr)}
+}
+
+// allowed reports whether the path and query of rawURL may be fetched. The longest
+// matching pattern wins and Allow wins a tie.
+func (r *robotsRules) allowed(rawURL string) bool {
+	if r == nil {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if target == "/robots.txt" {
+		return true
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(target) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// disallowAll is used when robots.txt exists but cannot be read because of a server error
+var disallowAll = &robotsRules{rules: []robotsRule{newRobotsRule(false, "/")}}
+
+// robotsEntry makes sure each host's robots.txt is fetched once
+type robotsEntry struct {
+	once  sync.Once
+	rules *robotsRules
+}
+
+// robotsFor returns the robots.txt rules for the host of rawURL, fetching them on
+// first use. A missing robots.txt allows everything; a server error refuses
+// everything. A Crawl-delay becomes the minimum wait for that host.
+func (m *mirrorer) robotsFor(rawURL string) *robotsRules {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	key := u.Scheme + "://" + u.Host
+
+	m.mu.Lock()
+	entry, ok := m.robots[key]
+	if !ok {
+		entry = &robotsEntry{}
+		m.robots[key] = entry
+	}
+	m.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.rules = m.fetchRobots(key + "/robots.txt")
+		if entry.rules != nil && entry.rules.crawlDelay > 0 {
+			fmt.Printf("Using Crawl-delay of %v for %s\n", entry.rules.crawlDelay, u.Host)
+			m.sched.setMinWait(u.Host, entry.rules.crawlDelay)
+		}
+	})
+	return entry.rules
+}
+
+// fetchRobots downloads and parses one robots.txt file
+func (m *mirrorer) fetchRobots(robotsURL string) *robotsRules {
+	fmt.Printf("Fetching %s\n", robotsURL)
+	release := m.sched.acquireURL(robotsURL)
+	defer release()
+
+	req, err := newCrawlRequest(http.MethodGet, robotsURL)
+	if err != nil {
+		fmt.Printf("Error fetching %s: %v\n", robotsURL, err)
+		return nil
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Error fetching %s: %v\n", robotsURL, err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		fmt.Printf("Server error for %s (%s), not crawling this host\n", robotsURL, resp.Status)
+		return disallowAll
+	case resp.StatusCode != http.StatusOK:
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", robotsURL, err)
+		return nil
+	}
+	return parseRobots(data, robotsAgent)
+}
+
+// robotsAllowed reports whether robots.txt lets the mirror fetch rawURL
+func (m *mirrorer) robotsAllowed(rawURL string) bool {
+	if m.opts.IgnoreRobots {
+		return true
+	}
+	return m.robotsFor(rawURL).allowed(rawURL)
+}
+
+// metaRobots reads the <meta name="robots"> directives of an HTML page
+func metaRobots(data []byte) (noindex, nofollow bool) {
+	z := newHTMLTokenizer(data)
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			return noindex, nofollow
+		}
+		if tok.Type != startTagToken || tok.Tag != "meta" {
+			continue
+		}
+
+		var name, content string
+		for _, attr := range tok.Attrs {
+			switch attr.Name {
+			case "name":
+				name = strings.ToLower(strings.TrimSpace(attr.Value))
+			case "content":
+				content = strings.ToLower(attr.Value)
+			}
+		}
+		if name != "robots" && name != robotsAgent {
+			continue
+		}
+		for _, directive := range strings.FieldsFunc(content, func(r rune) bool { return r == ',' || r == ' ' }) {
+			switch directive {
+			case "noindex":
+				noindex = true
+			case "nofollow":
+				nofollow = true
+			case "none":
+				noindex, nofollow = true, true
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRobotsAllowed(t *testing.T) {
+	robots := `
+# Rules for everyone else
+User-agent: *
+Disallow: /
+
+User-agent: Googlebot
+User-agent: wget
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.pdf$
+Disallow: /search?q=
+Crawl-delay: 2
+
+Sitemap: https://example.com/sitemap.xml
+`
+	rules := parseRobots([]byte(robots), robotsAgent)
+
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{"https://example.com/", true},
+		{"https://example.com/private/a.html", false},
+		{"https://example.com/private/public/a.html", true},
+		{"https://example.com/docs/a.pdf", false},
+		{"https://example.com/docs/a.pdf?download=1", true},
+		{"https://example.com/search?q=go", false},
+		{"https://example.com/search", true},
+		{"https://example.com/robots.txt", true},
+	}
+	for _, tt := range tests {
+		if got := rules.allowed(tt.url); got != tt.expected {
+			t.Errorf("allowed(%q) = %v; want %v", tt.url, got, tt.expected)
+		}
+	}
+
+	if rules.crawlDelay != 2*time.Second {
+		t.Errorf("crawlDelay = %v; want 2s", rules.crawlDelay)
+	}
+	if len(rules.sitemaps) != 1 || rules.sitemaps[0] != "https://example.com/sitemap.xml" {
+		t.Errorf("sitemaps = %v", rules.sitemaps)
+	}
+}
+
+func TestRobotsFallbackGroup(t *testing.T) {
+	rules := parseRobots([]byte("User-agent: *\nDisallow: /tmp\nAllow: /tmp\n"), robotsAgent)
+	// Allow wins a tie between patterns of the same length
+	if !rules.allowed("http://example.com/tmp/a") {
+		t.Errorf("expected /tmp/a to be allowed")
+	}
+
+	rules = parseRobots([]byte("User-agent: other\nDisallow: /\n"), robotsAgent)
+	if !rules.allowed("http://example.com/a") {
+		t.Errorf("rules for other agents should not apply")
+	}
+
+	var none *robotsRules
+	if !none.allowed("http://example.com/a") {
+		t.Errorf("a missing robots.txt should allow everything")
+	}
+}
+
+func TestMetaRobots(t *testing.T) {
+	tests := []struct {
+		html     string
+		noindex  bool
+		nofollow bool
+	}{
+		{`<meta name="robots" content="noindex, nofollow">`, true, true},
+		{`<META NAME="Robots" CONTENT="NOFOLLOW">`, false, true},
+		{`<meta name="robots" content="none">`, true, true},
+		{`<meta name="description" content="noindex">`, false, false},
+		{`<p>nofollow</p>`, false, false},
+	}
+	for _, tt := range tests {
+		noindex, nofollow := metaRobots([]byte(tt.html))
+		if noindex != tt.noindex || nofollow != tt.nofollow {
+			t.Errorf("metaRobots(%q) = %v, %v; want %v, %v", tt.html, noindex, nofollow, tt.noindex, tt.nofollow)
+		}
+	}
+}
+
+func TestMirrorWebsiteRobots(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/robots.txt":     "User-agent: *\nDisallow: /private/\n",
+		"/":               `<html><body><a href="/a.html">A</a> <a href="/private/b.html">B</a> <a href="/c.html">C</a></body></html>`,
+		"/a.html":         `<html><head><meta name="robots" content="nofollow"></head><body><a href="/d.html">D</a></body></html>`,
+		"/private/b.html": `<html><body>B</body></html>`,
+		"/c.html":         `<html><head><meta name="robots" content="noindex"></head><body><a href="/e.html">E</a></body></html>`,
+		"/d.html":         `<html><body>D</body></html>`,
+		"/e.html":         `<html><body>E</body></html>`,
+	})
+	defer server.Close()
+	host, _ := url.Parse(server.URL)
+
+	chdirTemp(t)
+	if err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true}); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+	for _, want := range []string{"a.html", "e.html"} {
+		if _, err := os.Stat(filepath.Join(host.Host, want)); err != nil {
+			t.Errorf("expected %s to be mirrored: %v", want, err)
+		}
+	}
+	for _, unwanted := range []string{"private/b.html", "d.html", "c.html"} {
+		if _, err := os.Stat(filepath.Join(host.Host, unwanted)); !os.IsNotExist(err) {
+			t.Errorf("%s should not be in the mirror", unwanted)
+		}
+	}
+
+	chdirTemp(t)
+	opts := MirrorOptions{Recursive: true, IgnoreRobots: true}
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+	for _, want := range []string{"private/b.html", "d.html", "c.html"} {
+		if _, err := os.Stat(filepath.Join(host.Host, want)); err != nil {
+			t.Errorf("expected %s to be mirrored with robots=off: %v", want, err)
+		}
+	}
+}
+
+func TestMirrorWebsiteUserAgent(t *testing.T) {
+	pages := map[string]string{
+		"/robots.txt":  "User-agent: wget\nDisallow: /private/\n",
+		"/":            `<html><body><a href="/a.html">A</a><img src="/logo.png"></body></html>`,
+		"/a.html":      `<html><body>A</body></html>`,
+		"/orphan.html": `<html><body>Orphan</body></html>`,
+		"/logo.png":    "PNG",
+	}
+	var mu sync.Mutex
+	agents := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.UserAgent()] = true
+		mu.Unlock()
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	pages["/sitemap.xml"] = `<urlset><url><loc>` + server.URL + `/orphan.html</loc></url></urlset>`
+
+	// The server must see the agent whose robots.txt group is followed
+	chdirTemp(t)
+	for _, opts := range []MirrorOptions{{Recursive: true, Sitemap: true}, {Recursive: true, Spider: true}} {
+		if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+			t.Fatalf("MirrorWebsite failed: %v", err)
+		}
+	}
+	if len(agents) != 1 || !agents[robotsAgent] {
+		t.Errorf("requests were sent as %v; want only %q", agents, robotsAgent)
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPerHost is how many requests a mirror keeps open to one host at a time.
+const DefaultMaxPerHost = 5
+
+// hostScheduler spaces out and caps requests per host. A single scheduler is shared
+// by the whole crawl, so nested page and stylesheet scans cannot multiply the load
+// on an origin.
+type hostScheduler struct {
+	maxPerHost int
+	wait       time.Duration
+	randomWait bool
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+// hostSlot is the per-host state: a semaphore for open requests, the earliest
+// time the next request may start and any minimum wait the host asked for.
+type hostSlot struct {
+	sem     chan struct{}
+	mu      sync.Mutex
+	next    time.Time
+	minWait time.Duration
+}
+
+// newHostScheduler creates a scheduler allowing maxPerHost concurrent requests per
+// host with at least wait between the start of two requests to the same host.
+// With randomWait each pause is drawn from 0.5 to 1.5 times wait.
+func newHostScheduler(maxPerHost int, wait time.Duration, randomWait bool) *hostScheduler {
+	if maxPerHost <= 0 {
+		maxPerHost = DefaultMaxPerHost
+	}
+	return &hostScheduler{
+		maxPerHost: maxPerHost,
+		wait:       wait,
+		randomWait: randomWait,
+		hosts:      make(map[string]*hostSlot),
+	}
+}
+
+// acquire blocks until a request to host may start. Every acquire must be paired
+// with a release once the response has been consumed, and before any other request
+// to the same host: with --max-per-host=1 a caller holding its slot would wait on itself.
+func (s *hostScheduler) acquire(host string) {
+	slot := s.slot(host)
+	slot.sem <- struct{}{}
+
+	// Holding the slot lock while sleeping keeps waiting requests in order
+	slot.mu.Lock()
+	if pause := time.Until(slot.next); pause > 0 {
+		time.Sleep(pause)
+	}
+	delay := s.delay()
+	if delay < slot.minWait {
+		delay = slot.minWait
+	}
+	slot.next = time.Now().Add(delay)
+	slot.mu.Unlock()
+}
+
+// setMinWait makes requests to host at least wait apart, whatever --wait says
+func (s *hostScheduler) setMinWait(host string, wait time.Duration) {
+	slot := s.slot(host)
+	slot.mu.Lock()
+	slot.minWait = wait
+	slot.mu.Unlock()
+}
+
+// release frees the connection taken by acquire
+func (s *hostScheduler) release(host string) {
+	<-s.slot(host).sem
+}
+
+// acquireURL is acquire keyed by the host of rawURL. It returns the matching release.
+func (s *hostScheduler) acquireURL(rawURL string) func() {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	s.acquire(host)
+	return func() { s.release(host) }
+}
+
+// slot returns the state for host, creating it on first use
+func (s *hostScheduler) slot(host string) *hostSlot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slot, ok := s.hosts[host]
+	if !ok {
+		slot = &hostSlot{sem: make(chan struct{}, s.maxPerHost)}
+		s.hosts[host] = slot
+	}
+	return slot
+}
+
+// delay returns the pause to leave before the next request to the same host
+func (s *hostScheduler) delay() time.Duration {
+	if s.wait <= 0 || !s.randomWait {
+		return s.wait
+	}
+	return time.Duration((0.5 + rand.Float64()) * float64(s.wait))
+}
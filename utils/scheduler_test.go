@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostSchedulerCapsConnectionsPerHost(t *testing.T) {
+	sched := newHostScheduler(2, 0, false)
+
+	var mu sync.Mutex
+	active := map[string]int{}
+	peak := map[string]int{}
+	var wg sync.WaitGroup
+
+	for i := 0; i < 12; i++ {
+		host := "a.example"
+		if i%2 == 1 {
+			host = "b.example"
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			sched.acquire(host)
+			defer sched.release(host)
+
+			mu.Lock()
+			active[host]++
+			if active[host] > peak[host] {
+				peak[host] = active[host]
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			active[host]--
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	for host, n := range peak {
+		if n > 2 {
+			t.Errorf("peak connections to %s = %d; want at most 2", host, n)
+		}
+	}
+}
+
+func TestHostSchedulerWaitsBetweenRequests(t *testing.T) {
+	wait := 30 * time.Millisecond
+	sched := newHostScheduler(5, wait, false)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release := sched.acquireURL("http://example.com/page")
+		release()
+	}
+
+	// Three requests need two pauses between them
+	if elapsed := time.Since(start); elapsed < 2*wait {
+		t.Errorf("three requests took %v; want at least %v", elapsed, 2*wait)
+	}
+
+	// Another host is not held back by the first one
+	start = time.Now()
+	release := sched.acquireURL("http://other.example/")
+	release()
+	if elapsed := time.Since(start); elapsed >= wait {
+		t.Errorf("first request to a new host waited %v", elapsed)
+	}
+}
+
+func TestHostSchedulerRandomWait(t *testing.T) {
+	wait := 100 * time.Millisecond
+	sched := newHostScheduler(1, wait, true)
+
+	for i := 0; i < 50; i++ {
+		d := sched.delay()
+		if d < wait/2 || d > wait*3/2 {
+			t.Fatalf("random delay %v outside [%v, %v]", d, wait/2, wait*3/2)
+		}
+	}
+}
+
+func TestMirrorWebsiteOneConnectionPerHost(t *testing.T) {
+	server := newTestSite(map[string]string{
+		"/":          `<html><head><link rel="stylesheet" href="/style.css"></head><body><a href="/a.html">A</a><img src="/logo.png"></body></html>`,
+		"/a.html":    `<html><body><img src="/logo.png"></body></html>`,
+		"/style.css": `body { background: url(/bg.png) }`,
+		"/logo.png":  "png",
+		"/bg.png":    "png",
+	})
+	defer server.Close()
+	chdirTemp(t)
+
+	// A page must give up its connection before its resources ask for one
+	for _, opts := range []MirrorOptions{
+		{Recursive: true, MaxPerHost: 1},
+		{Recursive: true, MaxPerHost: 1, Spider: true},
+	} {
+		done := make(chan error, 1)
+		go func() { done <- MirrorWebsite(server.URL+"/", opts) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("mirror with --max-per-host=1 (spider %v) failed: %v", opts.Spider, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("mirror with --max-per-host=1 (spider %v) deadlocked", opts.Spider)
+		}
+	}
+}
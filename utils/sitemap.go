@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxSitemapSize is the largest uncompressed sitemap the protocol allows
+const maxSitemapSize = 50 * 1024 * 1024
+
+// maxSitemapFiles stops a sitemap index that keeps pointing at more indexes
+const maxSitemapFiles = 1000
+
+// sitemapURL is one page listed in a sitemap
+type sitemapURL struct {
+	Loc     string
+	LastMod time.Time // zero when the sitemap does not say
+}
+
+// sitemapDoc matches both a <urlset> and a <sitemapindex> document
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// lastModLayouts are the W3C datetime forms allowed in <lastmod>
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// parseSitemap reads a sitemap, gzipped or not. It returns the pages of a urlset
+// and the child sitemaps of a sitemap index.
+func parseSitemap(data []byte) ([]sitemapURL, []string, error) {
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip data: %v", err)
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, maxSitemapSize))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip data: %v", err)
+		}
+	}
+
+	var doc sitemapDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid sitemap: %v", err)
+	}
+
+	var urls []sitemapURL
+	var children []string
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, entry := range doc.URLs {
+			if loc := strings.TrimSpace(entry.Loc); loc != "" {
+				urls = append(urls, sitemapURL{Loc: loc, LastMod: parseLastMod(entry.LastMod)})
+			}
+		}
+	case "sitemapindex":
+		for _, entry := range doc.Sitemaps {
+			if loc := strings.TrimSpace(entry.Loc); loc != "" {
+				children = append(children, loc)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid sitemap: unexpected <%s> element", doc.XMLName.Local)
+	}
+	return urls, children, nil
+}
+
+// parseLastMod converts a <lastmod> value, returning the zero time when it is missing
+// or malformed
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// sitemapPages finds the sitemaps of the host of startURL, from the Sitemap lines of
+// its robots.txt or else /sitemap.xml, and returns every page they list that the
+// mirror would accept. Sitemap indexes are followed, each sitemap is read once.
+func (m *mirrorer) sitemapPages(startURL string) []sitemapURL {
+	u, err := url.Parse(startURL)
+	if err != nil {
+		return nil
+	}
+
+	var queue []string
+	if !m.opts.IgnoreRobots {
+		queue = append(queue, m.robotsFor(startURL).sitemapList()...)
+	}
+	if len(queue) == 0 {
+		queue = []string{u.Scheme + "://" + u.Host + "/sitemap.xml"}
+	}
+
+	seen := make(map[string]bool)
+	var pages []sitemapURL
+	for len(queue) > 0 && len(seen) < maxSitemapFiles {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+
+		data, err := m.fetchSitemap(sitemapURL)
+		if err != nil {
+			fmt.Printf("Error reading sitemap %s: %v\n", sitemapURL, err)
+			continue
+		}
+		urls, children, err := parseSitemap(data)
+		if err != nil {
+			fmt.Printf("Error reading sitemap %s: %v\n", sitemapURL, err)
+			continue
+		}
+		queue = append(queue, children...)
+
+		for _, page := range urls {
+			if m.acceptSeed(page.Loc) {
+				pages = append(pages, page)
+			}
+		}
+	}
+	fmt.Printf("Found %d pages in %d sitemaps\n", len(pages), len(seen))
+	return pages
+}
+
+// sitemapList returns the Sitemap lines of a robots.txt, if there was one
+func (r *robotsRules) sitemapList() []string {
+	if r == nil {
+		return nil
+	}
+	return r.sitemaps
+}
+
+// fetchSitemap downloads one sitemap file without saving it to the mirror
+func (m *mirrorer) fetchSitemap(sitemapURL string) ([]byte, error) {
+	fmt.Printf("Fetching sitemap %s\n", sitemapURL)
+	release := m.sched.acquireURL(sitemapURL)
+	defer release()
+
+	req, err := newCrawlRequest(http.MethodGet, sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", sitemapURL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSitemapSize))
+}
+
+// acceptSeed reports whether a page listed in a sitemap passes the same host, filter
+// and robots.txt checks as a link found while crawling
+func (m *mirrorer) acceptSeed(pageURL string) bool {
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if !m.acceptHost(pageURL) || !m.filter.allowsURL(pageURL) {
+		return false
+	}
+	if !m.filter.allowsName(pageURL) && !looksLikePage(pageURL) {
+		return false
+	}
+	return m.robotsAllowed(pageURL)
+}
+
+// upToDate looks for the copy of fileURL saved by an earlier mirror. It is only used
+// when the sitemap gave fileURL a lastmod, and the copy must be at least that recent.
+func (m *mirrorer) upToDate(fileURL string) (string, bool) {
+	m.mu.Lock()
+	lastMod, ok := m.lastMod[normalizeURL(fileURL)]
+	m.mu.Unlock()
+	if !ok || lastMod.IsZero() {
+		return "", false
+	}
+
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", false
+	}
+	for _, kind := range []contentKind{otherContent, htmlContent, cssContent} {
+		candidate := m.localPath(u, kind)
+		info, err := os.Stat(m.fullPath(candidate))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.ModTime().Before(lastMod) {
+			return "", false
+		}
+		return candidate, true
+	}
+	return "", false
+}
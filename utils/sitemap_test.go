@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatalf("gzip failed: %v", err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/a.html</loc><lastmod>2024-03-01</lastmod></url>
+  <url><loc> https://example.com/b.html </loc></url>
+</urlset>`
+
+	for name, data := range map[string][]byte{"plain": []byte(urlset), "gzipped": gzipBytes(t, urlset)} {
+		urls, children, err := parseSitemap(data)
+		if err != nil {
+			t.Fatalf("%s: parseSitemap failed: %v", name, err)
+		}
+		if len(children) != 0 || len(urls) != 2 {
+			t.Fatalf("%s: got %d urls and %d sitemaps; want 2 and 0", name, len(urls), len(children))
+		}
+		if urls[0].Loc != "https://example.com/a.html" || !urls[0].LastMod.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("%s: unexpected first entry %+v", name, urls[0])
+		}
+		if urls[1].Loc != "https://example.com/b.html" || !urls[1].LastMod.IsZero() {
+			t.Errorf("%s: unexpected second entry %+v", name, urls[1])
+		}
+	}
+
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/docs.xml.gz</loc></sitemap>
+</sitemapindex>`
+	urls, children, err := parseSitemap([]byte(index))
+	if err != nil {
+		t.Fatalf("parseSitemap failed: %v", err)
+	}
+	if len(urls) != 0 || len(children) != 1 || children[0] != "https://example.com/docs.xml.gz" {
+		t.Errorf("unexpected index result: %v %v", urls, children)
+	}
+
+	if _, _, err := parseSitemap([]byte("<html></html>")); err == nil {
+		t.Errorf("expected an error for a document that is not a sitemap")
+	}
+}
+
+func TestParseLastMod(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2024-03-01T10:30:00+02:00", time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)},
+		{"2024-03-01T10:30Z", time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"2024-03", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"yesterday", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := parseLastMod(tt.value); !got.Equal(tt.expected) {
+			t.Errorf("parseLastMod(%q) = %v; want %v", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestMirrorWebsiteSitemap(t *testing.T) {
+	var server *httptest.Server
+	pages := map[string]string{
+		"/":             `<html><body>Home</body></html>`,
+		"/hidden.html":  `<html><body><a href="/linked.html">Linked</a></body></html>`,
+		"/linked.html":  `<html><body>Linked</body></html>`,
+		"/private.html": `<html><body>Private</body></html>`,
+	}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /private.html\nSitemap: " + server.URL + "/index.xml\n"))
+		case "/index.xml":
+			w.Write([]byte(`<sitemapindex><sitemap><loc>` + server.URL + `/pages.xml.gz</loc></sitemap></sitemapindex>`))
+		case "/pages.xml.gz":
+			w.Write(gzipBytes(t, `<urlset>
+<url><loc>`+server.URL+`/hidden.html</loc></url>
+<url><loc>`+server.URL+`/private.html</loc></url>
+<url><loc>http://other.invalid/page.html</loc></url>
+</urlset>`))
+		default:
+			body, ok := pages[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+	chdirTemp(t)
+
+	if err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, Sitemap: true}); err != nil {
+		t.Fatalf("MirrorWebsite failed: %v", err)
+	}
+
+	host, _ := url.Parse(server.URL)
+	for _, want := range []string{"index.html", "hidden.html", "linked.html"} {
+		if _, err := os.Stat(filepath.Join(host.Host, want)); err != nil {
+			t.Errorf("expected %s to be mirrored: %v", want, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(host.Host, "private.html")); !os.IsNotExist(err) {
+		t.Errorf("private.html is disallowed by robots.txt and should not be mirrored")
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	m := &mirrorer{root: dir, lastMod: make(map[string]time.Time)}
+	saved := filepath.Join(dir, "example.com", "docs", "index.html")
+	if err := os.MkdirAll(filepath.Dir(saved), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(saved, []byte("<html></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	savedAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(saved, savedAt, savedAt)
+
+	pageURL := "https://example.com/docs"
+	if _, ok := m.upToDate(pageURL); ok {
+		t.Errorf("a page without a lastmod should always be downloaded")
+	}
+
+	m.lastMod[normalizeURL(pageURL)] = savedAt.Add(-time.Hour)
+	if got, ok := m.upToDate(pageURL); !ok || got != "example.com/docs/index.html" {
+		t.Errorf("upToDate = %q, %v; want the saved copy", got, ok)
+	}
+
+	m.lastMod[normalizeURL(pageURL)] = savedAt.Add(time.Hour)
+	if _, ok := m.upToDate(pageURL); ok {
+		t.Errorf("a copy older than lastmod should be downloaded again")
+	}
+}
+
+func TestMirrorWebsiteSitemapKeepsState(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(`<urlset><url><loc>` + server.URL + `/page.html</loc><lastmod>2001-01-01</lastmod></url></urlset>`))
+		case "/", "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body>Page</body></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+	stateFile := filepath.Join(dir, host.Host, mirrorStateName)
+
+	opts := MirrorOptions{Recursive: true, Sitemap: true}
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("first mirror failed: %v", err)
+	}
+	// A copy newer than its lastmod but unknown to the state file is kept and tracked
+	os.Remove(stateFile)
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("second mirror failed: %v", err)
+	}
+	m := &mirrorer{root: dir}
+	state := m.readState(filepath.Join(host.Host, mirrorStateName))
+	entry := state[normalizeURL(server.URL+"/page.html")]
+	if entry == nil || entry.Path != host.Host+"/page.html" {
+		t.Errorf("page kept for its sitemap lastmod should be in the state file, got %+v", entry)
+	}
+}
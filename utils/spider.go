@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxSpiderPage is the most of a page --spider reads to find its links
+const maxSpiderPage = 10 * 1024 * 1024
+
+// probeEntry makes sure --spider checks each URL once however many pages link to it
+type probeEntry struct {
+	once   sync.Once
+	result probeResult
+}
+
+// probeResult is what checking a URL found
+type probeResult struct {
+	status string // the response status, or the error when there was no response
+	broken bool
+	isHTML bool // worth fetching in full for its links
+}
+
+// spiderRef is a page linking to a URL, with the text of the link
+type spiderRef struct {
+	Page string
+	Text string
+}
+
+// spider is --spider: it crawls like a mirror, with the same depth, host, filter and
+// robots.txt rules, but saves nothing. Every link is checked with HEAD, and pages
+// are fetched with GET only to read their links. At the end it prints the broken
+// links with every page referring to them and returns an error if there were any.
+func (m *mirrorer) spider(startURL string) error {
+	visited := map[string]bool{normalizeURL(startURL): true}
+	frontier := []crawlItem{{url: startURL, depth: 0}}
+	refs := make(map[string][]spiderRef) // normalised URL -> pages linking to it
+	checked := make(map[string]string)   // normalised URL -> URL as linked
+	checked[normalizeURL(startURL)] = startURL
+	pages := 0
+
+	for len(frontier) > 0 {
+		if !m.budget.take() {
+			break
+		}
+		item := frontier[0]
+		frontier = frontier[1:]
+
+		result := m.probe(item.url)
+		if result.broken || !result.isHTML {
+			continue
+		}
+		body, isHTML, err := m.fetchPage(item.url)
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", item.url, err)
+			continue
+		}
+		if !isHTML {
+			continue
+		}
+		pages++
+
+		followLinks := m.followLinks(item.depth)
+		if !m.opts.IgnoreRobots {
+			if _, nofollow := metaRobots(body); nofollow {
+				followLinks = false
+			}
+		}
+
+		// Check every link of the page at once, each URL only the first time
+		baseURL := documentBase(body, item.url)
+		var toCheck []string
+		for _, link := range extractLinkTexts(body) {
+			if link.Kind == pageLink && !followLinks {
+				continue
+			}
+			if shouldSkipResource(link.URL) {
+				continue
+			}
+			absoluteURL, _, _ := strings.Cut(resolveURL(baseURL, link.URL), "#")
+			if absoluteURL == "" || !m.acceptHost(absoluteURL) || !m.spiderAllows(absoluteURL, link.Kind) {
+				continue
+			}
+			key := normalizeURL(absoluteURL)
+			refs[key] = append(refs[key], spiderRef{Page: item.url, Text: link.Text})
+			if _, ok := checked[key]; !ok {
+				checked[key] = absoluteURL
+				toCheck = append(toCheck, absoluteURL)
+			}
+		}
+		var wg sync.WaitGroup
+		for _, linkURL := range toCheck {
+			wg.Add(1)
+			go func(linkURL string) {
+				defer wg.Done()
+				m.probe(linkURL)
+			}(linkURL)
+		}
+		wg.Wait()
+
+		// Pages past the depth limit are checked but not read
+		if !m.opts.Recursive || !m.followLinks(item.depth+1) {
+			continue
+		}
+		for _, linkURL := range toCheck {
+			key := normalizeURL(linkURL)
+			if visited[key] || !m.probe(linkURL).isHTML {
+				continue
+			}
+			visited[key] = true
+			frontier = append(frontier, crawlItem{url: linkURL, depth: item.depth + 1})
+		}
+	}
+
+	if stopped := m.budget.summary(); stopped != "" {
+		fmt.Printf("\nStopped early, %s: %d pages not crawled\n", stopped, len(frontier))
+	}
+	return m.spiderReport(pages, checked, refs)
+}
+
+// spiderAllows applies the -A/-R, -I/-X and robots.txt rules to a link. A refused
+// page is still checked when it can lead to more pages, as while mirroring.
+func (m *mirrorer) spiderAllows(linkURL string, kind linkKind) bool {
+	if !m.filter.allowsURL(linkURL) {
+		return false
+	}
+	if !m.filter.allowsName(linkURL) && !(kind == pageLink && m.opts.Recursive && looksLikePage(linkURL)) {
+		return false
+	}
+	return m.robotsAllowed(linkURL)
+}
+
+// brokenLink is a URL that could not be fetched, with the pages linking to it
+type brokenLink struct {
+	URL    string
+	Status string
+	Refs   []spiderRef
+}
+
+// spiderReport prints the broken links found by the crawl and returns an error if
+// there were any
+func (m *mirrorer) spiderReport(pages int, checked map[string]string, refs map[string][]spiderRef) error {
+	var broken []brokenLink
+	for key, linkURL := range checked {
+		if result := m.probe(linkURL); result.broken {
+			broken = append(broken, brokenLink{URL: linkURL, Status: result.status, Refs: refs[key]})
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].URL < broken[j].URL })
+
+	fmt.Printf("\n=== Spider finished: %d pages crawled, %d URLs checked ===\n", pages, len(checked))
+	writeSpiderReport(os.Stdout, broken)
+	if len(broken) > 0 {
+		return fmt.Errorf("%d broken links found", len(broken))
+	}
+	return nil
+}
+
+// writeSpiderReport writes the broken links sorted by URL, each with its status and
+// every page referring to it with the text of the link
+func writeSpiderReport(w io.Writer, broken []brokenLink) {
+	if len(broken) == 0 {
+		fmt.Fprintln(w, "No broken links found")
+		return
+	}
+	fmt.Fprintf(w, "\nFound %d broken links:\n", len(broken))
+	for _, link := range broken {
+		fmt.Fprintf(w, "\n%s\n  %s\n", link.URL, link.Status)
+		if len(link.Refs) == 0 {
+			fmt.Fprintln(w, "  start URL")
+		}
+		for _, ref := range link.Refs {
+			if ref.Text == "" {
+				fmt.Fprintf(w, "  linked from %s\n", ref.Page)
+			} else {
+				fmt.Fprintf(w, "  linked from %s (%q)\n", ref.Page, ref.Text)
+			}
+		}
+	}
+}
+
+// probe checks rawURL with a HEAD request, once per URL. Servers that refuse HEAD are
+// asked with GET instead, without reading the body.
+func (m *mirrorer) probe(rawURL string) probeResult {
+	key := normalizeURL(rawURL)
+	m.mu.Lock()
+	entry, ok := m.probes[key]
+	if !ok {
+		entry = &probeEntry{}
+		m.probes[key] = entry
+	}
+	m.mu.Unlock()
+
+	entry.once.Do(func() {
+		if !m.opts.IgnoreRobots {
+			m.robotsFor(rawURL)
+		}
+		fmt.Printf("Checking %s\n", rawURL)
+		release := m.sched.acquireURL(rawURL)
+		defer release()
+		resp, err := m.spiderRequest(http.MethodHead, rawURL)
+		if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+			resp.Body.Close()
+			resp, err = m.spiderRequest(http.MethodGet, rawURL)
+		}
+		if err != nil {
+			entry.result = probeResult{status: err.Error(), broken: true}
+			return
+		}
+		resp.Body.Close()
+
+		contentType := resp.Header.Get("Content-Type")
+		entry.result = probeResult{
+			status: resp.Status,
+			broken: resp.StatusCode >= 400,
+			// Without a Content-Type only the body can tell
+			isHTML: contentType == "" || detectContent(contentType, nil) == htmlContent,
+		}
+	})
+	return entry.result
+}
+
+// fetchPage reads a page for its links. It also reports whether the page turned out
+// to be HTML, which a server that sends no Content-Type leaves to the body.
+func (m *mirrorer) fetchPage(pageURL string) ([]byte, bool, error) {
+	release := m.sched.acquireURL(pageURL)
+	defer release()
+	resp, err := m.spiderRequest(http.MethodGet, pageURL)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, &statusError{url: pageURL, status: resp.Status, code: resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(m.budget.reader(m.limitReader(resp.Body)), maxSpiderPage))
+	if err != nil {
+		return nil, false, err
+	}
+	head := body
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return body, detectContent(resp.Header.Get("Content-Type"), head) == htmlContent, nil
+}
+
+// spiderRequest sends one request, leaving the caller to hold a scheduler slot
+func (m *mirrorer) spiderRequest(method, rawURL string) (*http.Response, error) {
+	req, err := newCrawlRequest(method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMirrorWebsiteSpider(t *testing.T) {
+	pages := map[string]string{
+		"/":            `<html><body><a href="/a.html">About us</a> <a href="/gone.html">Old news</a> <img src="/missing.png" alt="Logo"></body></html>`,
+		"/a.html":      `<html><body><a href="/gone.html">Archive</a> <a href="/report.pdf">Report</a> <a href="/nohead.html">No HEAD</a></body></html>`,
+		"/report.pdf":  "%PDF",
+		"/nohead.html": `<html><body>fine</body></html>`,
+	}
+	var mu sync.Mutex
+	requests := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], r.Method)
+		mu.Unlock()
+		if r.URL.Path == "/nohead.html" && r.Method == http.MethodHead {
+			http.Error(w, "no HEAD here", http.StatusMethodNotAllowed)
+			return
+		}
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".pdf") {
+			w.Header().Set("Content-Type", "application/pdf")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	dir := chdirTemp(t)
+
+	err := MirrorWebsite(server.URL+"/", MirrorOptions{Recursive: true, Spider: true})
+	if err == nil || !strings.Contains(err.Error(), "2 broken links") {
+		t.Errorf("expected 2 broken links to be reported, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(requests["/report.pdf"], ","); got != "HEAD" {
+		t.Errorf("files should only be checked with HEAD, got %s", got)
+	}
+	if got := strings.Join(requests["/a.html"], ","); got != "HEAD,GET" {
+		t.Errorf("pages should be checked with HEAD and read with GET, got %s", got)
+	}
+	if got := requests["/gone.html"]; len(got) != 1 {
+		t.Errorf("a broken link should be checked once however many pages refer to it, got %v", got)
+	}
+	if got := strings.Join(requests["/nohead.html"], ","); !strings.HasPrefix(got, "HEAD,GET") {
+		t.Errorf("a server refusing HEAD should be asked with GET, got %s", got)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("--spider should not save anything, found %d entries", len(entries))
+	}
+}
+
+func TestWriteSpiderReport(t *testing.T) {
+	var out bytes.Buffer
+	writeSpiderReport(&out, []brokenLink{
+		{URL: "http://example.com/", Status: "503 Service Unavailable"},
+		{URL: "http://example.com/gone.html", Status: "404 Not Found", Refs: []spiderRef{
+			{Page: "http://example.com/", Text: "Old news"},
+			{Page: "http://example.com/a.html"},
+		}},
+	})
+	for _, want := range []string{
+		"Found 2 broken links",
+		"http://example.com/\n  503 Service Unavailable\n  start URL",
+		"http://example.com/gone.html\n  404 Not Found\n  linked from http://example.com/ (\"Old news\")\n  linked from http://example.com/a.html\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	writeSpiderReport(&out, nil)
+	if !strings.Contains(out.String(), "No broken links") {
+		t.Errorf("empty report should say so: %s", out.String())
+	}
+}
@@ -0,0 +1,288 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mirrorStateName is the file in each host folder that remembers what the last mirror
+// saved, so the next run can ask the server for changed files only
+const mirrorStateName = ".wget-mirror.json"
+
+// urlState is what the state file remembers about one downloaded URL
+type urlState struct {
+	Path         string      `json:"path"` // relative to the mirror root
+	Kind         contentKind `json:"kind,omitempty"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	SHA256       string      `json:"sha256,omitempty"` // of the body as served, before conversion
+	fileScan
+
+	// Converted maps each value link conversion wrote into the file to the absolute
+	// URL it stood for, so an unchanged file can be converted again
+	Converted map[string]string `json:"converted,omitempty"`
+}
+
+// fileScan is what parsing a page or stylesheet found in it
+type fileScan struct {
+	Links    []stateLink `json:"links,omitempty"`
+	NoFollow bool        `json:"nofollow,omitempty"`
+	NoIndex  bool        `json:"noindex,omitempty"`
+}
+
+// stateLink is a link found in a file, resolved to an absolute URL
+type stateLink struct {
+	URL    string   `json:"url"`
+	Kind   linkKind `json:"kind,omitempty"`
+	Import bool     `json:"import,omitempty"` // an @import in a stylesheet
+}
+
+// mirrorState is the content of a state file
+type mirrorState struct {
+	URLs map[string]*urlState `json:"urls"` // normalised URL -> state
+}
+
+// stateLoad reads a state file left by an earlier run once, when first needed
+type stateLoad struct {
+	once sync.Once
+	urls map[string]*urlState
+}
+
+// stateFile returns where the state of the host of u is kept, relative to the mirror
+// root: in the host folder, or in the root under a name holding the host when there
+// are no host folders
+func (m *mirrorer) stateFile(u *url.URL) string {
+	if m.opts.NoDirectories || m.opts.NoHostDirectories {
+		return ".wget-mirror-" + escapeFileName(strings.ToLower(u.Host), m.names) + ".json"
+	}
+	hostRoot := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}
+	return path.Join(path.Dir(m.localPath(hostRoot, otherContent)), mirrorStateName)
+}
+
+// previous returns what an earlier run saved for fileURL, if its file is still there
+func (m *mirrorer) previous(fileURL string) *urlState {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return nil
+	}
+	stateFile := m.stateFile(u)
+
+	m.mu.Lock()
+	load, ok := m.prevState[stateFile]
+	if !ok {
+		load = &stateLoad{}
+		m.prevState[stateFile] = load
+	}
+	m.mu.Unlock()
+
+	load.once.Do(func() {
+		load.urls = m.readState(stateFile)
+		m.reservePaths(load.urls)
+	})
+	prev, ok := load.urls[normalizeURL(fileURL)]
+	if !ok || prev.Path == "" || insideRoot(prev.Path) != nil {
+		return nil
+	}
+	if info, err := os.Stat(m.fullPath(prev.Path)); err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	entry := *prev
+	return &entry
+}
+
+// readState loads a state file, returning nothing when there is none yet
+func (m *mirrorer) readState(stateFile string) map[string]*urlState {
+	data, err := os.ReadFile(m.fullPath(stateFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error reading mirror state %s: %v\n", m.fullPath(stateFile), err)
+		}
+		return nil
+	}
+	var state mirrorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		fmt.Printf("Ignoring invalid mirror state %s: %v\n", m.fullPath(stateFile), err)
+		return nil
+	}
+	return state.URLs
+}
+
+// setState records the state of a URL saved by this run
+func (m *mirrorer) setState(fileURL string, entry *urlState, unchanged bool) {
+	key := normalizeURL(fileURL)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state[key] = entry
+	if unchanged {
+		m.unchanged[key] = true
+	}
+}
+
+// reservePaths keeps the names an earlier run saved files under for the URLs they
+// were saved for. Otherwise a changed URL could take the name of an unchanged one
+// when -nd, -nH or --cut-dirs make names collide, and overwrite its copy.
+func (m *mirrorer) reservePaths(urls map[string]*urlState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, entry := range urls {
+		if _, taken := m.paths[entry.Path]; !taken && entry.Path != "" {
+			m.paths[entry.Path] = key
+		}
+	}
+}
+
+// keepPrevious reuses the copy an earlier run saved for fileURL instead of
+// downloading it again. Its path must already be reserved for fileURL.
+func (m *mirrorer) keepPrevious(fileURL string, prev *urlState) string {
+	m.recordContent(fileURL, prev.Kind)
+	m.setState(fileURL, prev, true)
+	fmt.Printf("Unchanged: %s -> %s\n", fileURL, m.fullPath(prev.Path))
+	return prev.Path
+}
+
+// scanFile returns the links of the file saved for fileURL. A file unchanged since the
+// last run reuses the links found in it then; any other is read and handed to scan,
+// whose resolve turns a raw link into an absolute URL.
+func (m *mirrorer) scanFile(fileURL, relativePath string, scan func(body []byte, resolve func(baseURL, link string) string) fileScan) (fileScan, error) {
+	key := normalizeURL(fileURL)
+	m.mu.Lock()
+	entry := m.state[key]
+	if entry != nil && m.unchanged[key] && entry.Links != nil {
+		defer m.mu.Unlock()
+		return entry.fileScan, nil
+	}
+	m.mu.Unlock()
+
+	body, err := os.ReadFile(m.fullPath(relativePath))
+	if err != nil {
+		return fileScan{}, err
+	}
+	var converted map[string]string
+	if entry != nil {
+		converted = entry.Converted
+	}
+	result := scan(body, func(baseURL, link string) string {
+		if absoluteURL, ok := translateLink(converted, link); ok {
+			return absoluteURL
+		}
+		return resolveURL(baseURL, link)
+	})
+
+	if entry != nil {
+		m.mu.Lock()
+		entry.fileScan = result
+		m.mu.Unlock()
+	}
+	return result, nil
+}
+
+// translateLink maps a value written by an earlier link conversion back to the
+// absolute URL it stood for, keeping any fragment
+func translateLink(converted map[string]string, link string) (string, bool) {
+	value, fragment, hasFragment := strings.Cut(link, "#")
+	absoluteURL, ok := converted[value]
+	if !ok {
+		return "", false
+	}
+	if hasFragment {
+		absoluteURL += "#" + fragment
+	}
+	return absoluteURL, true
+}
+
+// convertedLinks returns what link conversion wrote into the file saved for fileURL
+// the last time it was converted
+func (m *mirrorer) convertedLinks(fileURL string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry := m.state[normalizeURL(fileURL)]; entry != nil {
+		return entry.Converted
+	}
+	return nil
+}
+
+// setConverted records what link conversion wrote into the file saved for fileURL
+func (m *mirrorer) setConverted(fileURL string, converted map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry := m.state[normalizeURL(fileURL)]; entry != nil {
+		entry.Converted = converted
+	}
+}
+
+// saveState writes the state files of every host this run saved files for. Pages
+// removed at the end of the crawl are left out. What earlier runs saved for URLs this
+// run did not save is kept, unless the sync deleted their file, so a partial run such
+// as -p or a stopped crawl does not make the next one download everything again.
+func (m *mirrorer) saveState() {
+	files := make(map[string]*mirrorState)
+	m.mu.Lock()
+	for key, entry := range m.state {
+		if m.rejected[key] {
+			continue
+		}
+		u, err := url.Parse(key)
+		if err != nil {
+			continue
+		}
+		stateFile := m.stateFile(u)
+		if files[stateFile] == nil {
+			files[stateFile] = &mirrorState{URLs: make(map[string]*urlState)}
+		}
+		files[stateFile].URLs[key] = entry
+	}
+	for stateFile, load := range m.prevState {
+		load.once.Do(func() {})
+		for key, prev := range load.urls {
+			if _, saved := m.state[key]; saved || m.rejected[key] || m.deleted[prev.Path] {
+				continue
+			}
+			if files[stateFile] == nil {
+				files[stateFile] = &mirrorState{URLs: make(map[string]*urlState)}
+			}
+			files[stateFile].URLs[key] = prev
+		}
+	}
+	m.mu.Unlock()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := m.writeState(name, files[name]); err != nil {
+			fmt.Printf("Error saving mirror state %s: %v\n", m.fullPath(name), err)
+		}
+	}
+}
+
+// writeState replaces a state file
+func (m *mirrorer) writeState(stateFile string, state *mirrorState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return m.writeFile(stateFile, data)
+}
+
+// writeFile replaces a file of the mirror's own, going through a temporary file so an
+// interrupted run never leaves half of one
+func (m *mirrorer) writeFile(relativePath string, data []byte) error {
+	fullPath := m.fullPath(relativePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	tempPath := fullPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, fullPath)
+}
@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// conditionalSite serves pages with an ETag, answering 304 when the client already
+// has the current version. It counts the full responses it sends per path.
+type conditionalSite struct {
+	mu    sync.Mutex
+	pages map[string]string
+	sent  map[string]int
+}
+
+func (s *conditionalSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.pages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256([]byte(body))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.sent[r.URL.Path]++
+	w.Write([]byte(body))
+}
+
+func (s *conditionalSite) set(path, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[path] = body
+}
+
+func (s *conditionalSite) reset() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := s.sent
+	s.sent = make(map[string]int)
+	return sent
+}
+
+func TestMirrorWebsiteIncremental(t *testing.T) {
+	site := &conditionalSite{
+		pages: map[string]string{
+			"/":         `<html><body><a href="/a.html">A</a><img src="/logo.png"></body></html>`,
+			"/a.html":   `<html><body><a href="/">Home</a></body></html>`,
+			"/logo.png": "PNG",
+			"/b.html":   `<html><body><a href="/a.html">A</a></body></html>`,
+		},
+		sent: make(map[string]int),
+	}
+	server := httptest.NewServer(site)
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+	opts := MirrorOptions{Recursive: true, ConvertLinks: true}
+
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("first mirror failed: %v", err)
+	}
+	if sent := site.reset(); len(sent) != 3 {
+		t.Fatalf("first mirror should download 3 files, got %v", sent)
+	}
+	if _, err := os.Stat(filepath.Join(dir, host.Host, mirrorStateName)); err != nil {
+		t.Fatalf("mirror state was not saved: %v", err)
+	}
+
+	// Nothing changed: every file is answered with 304 and the links stay converted
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("second mirror failed: %v", err)
+	}
+	if sent := site.reset(); len(sent) != 0 {
+		t.Errorf("unchanged files were downloaded again: %v", sent)
+	}
+	a, _ := os.ReadFile(filepath.Join(dir, host.Host, "a.html"))
+	if !strings.Contains(string(a), `href="index.html"`) {
+		t.Errorf("unchanged page lost its converted links: %s", a)
+	}
+
+	// a.html changes and links to a new page, which links back to the unchanged index
+	site.set("/a.html", `<html><body><a href="/">Home</a> <a href="/b.html">B</a></body></html>`)
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("third mirror failed: %v", err)
+	}
+	sent := site.reset()
+	if sent["/a.html"] != 1 || sent["/b.html"] != 1 || len(sent) != 2 {
+		t.Errorf("only the changed page and the new one should be downloaded, got %v", sent)
+	}
+	a, _ = os.ReadFile(filepath.Join(dir, host.Host, "a.html"))
+	if !strings.Contains(string(a), `href="b.html"`) || !strings.Contains(string(a), `href="index.html"`) {
+		t.Errorf("changed page was not converted: %s", a)
+	}
+	index, _ := os.ReadFile(filepath.Join(dir, host.Host, "index.html"))
+	if !strings.Contains(string(index), `href="a.html"`) || !strings.Contains(string(index), `src="logo.png"`) {
+		t.Errorf("unchanged page was not converted again: %s", index)
+	}
+}
+
+func TestMirrorWebsitePartialRunKeepsState(t *testing.T) {
+	site := &conditionalSite{
+		pages: map[string]string{
+			"/":         `<html><body><a href="/a.html">A</a> <a href="/b.html">B</a><img src="/logo.png"></body></html>`,
+			"/a.html":   `<html><body><img src="/a.png"></body></html>`,
+			"/a.png":    "PNG",
+			"/b.html":   `<html><body>B</body></html>`,
+			"/logo.png": "PNG",
+		},
+		sent: make(map[string]int),
+	}
+	server := httptest.NewServer(site)
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+	mirror := MirrorOptions{Recursive: true, DeleteAfterSync: true}
+
+	if err := MirrorWebsite(server.URL+"/", mirror); err != nil {
+		t.Fatalf("first mirror failed: %v", err)
+	}
+	site.reset()
+
+	// A -p run only reaches the start page and its logo, and keeps the rest
+	if err := MirrorWebsite(server.URL+"/", MirrorOptions{PageRequisites: true}); err != nil {
+		t.Fatalf("-p run failed: %v", err)
+	}
+	if err := MirrorWebsite(server.URL+"/", mirror); err != nil {
+		t.Fatalf("mirror after -p failed: %v", err)
+	}
+	if sent := site.reset(); len(sent) != 0 {
+		t.Errorf("files an earlier run saved were downloaded again after a -p run: %v", sent)
+	}
+
+	// Files the sync deletes are forgotten
+	site.set("/", `<html><body><a href="/a.html">A</a><img src="/logo.png"></body></html>`)
+	if err := MirrorWebsite(server.URL+"/", mirror); err != nil {
+		t.Fatalf("mirror after a page was removed failed: %v", err)
+	}
+	m := &mirrorer{root: dir}
+	state := m.readState(filepath.Join(host.Host, mirrorStateName))
+	if _, ok := state[normalizeURL(server.URL+"/b.html")]; ok || len(state) != 4 {
+		t.Errorf("state should keep the 4 files still mirrored and forget the deleted page, got %d entries", len(state))
+	}
+}
+
+func TestMirrorWebsiteSameContent(t *testing.T) {
+	// Without validators the server resends everything, but identical bodies are
+	// recognised by their hash and the converted copies kept
+	server := newTestSite(map[string]string{
+		"/":       `<html><body><a href="/a.html">A</a></body></html>`,
+		"/a.html": `<html><body><a href="/">Home</a></body></html>`,
+	})
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+	opts := MirrorOptions{Recursive: true, ConvertLinks: true}
+
+	for run := 0; run < 2; run++ {
+		if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+			t.Fatalf("mirror %d failed: %v", run+1, err)
+		}
+	}
+	index, _ := os.ReadFile(filepath.Join(dir, host.Host, "index.html"))
+	if !strings.Contains(string(index), `href="a.html"`) {
+		t.Errorf("kept page should still be converted: %s", index)
+	}
+}
+
+func TestTranslateLink(t *testing.T) {
+	converted := map[string]string{"../a.html": "http://example.com/a.html"}
+	if got, ok := translateLink(converted, "../a.html#top"); !ok || got != "http://example.com/a.html#top" {
+		t.Errorf("translateLink = %q, %v", got, ok)
+	}
+	if _, ok := translateLink(converted, "b.html"); ok {
+		t.Errorf("unknown values should not be translated")
+	}
+}
+
+func TestMirrorWebsiteIncrementalKeepsNames(t *testing.T) {
+	site := &conditionalSite{
+		pages: map[string]string{
+			"/":   `<html><body><a href="/x/">X</a> <a href="/y/">Y</a></body></html>`,
+			"/x/": `<html><body>X</body></html>`,
+			"/y/": `<html><body>Y</body></html>`,
+		},
+		sent: make(map[string]int),
+	}
+	// The slow page is named last, whoever asks for a name first gets index.1.html
+	var slow atomic.Value
+	slow.Store("/y/")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == slow.Load() {
+			time.Sleep(50 * time.Millisecond)
+		}
+		site.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	dir := chdirTemp(t)
+	opts := MirrorOptions{Recursive: true, NoDirectories: true, DirectoryPrefix: "site"}
+	host, _ := url.Parse(server.URL)
+	stateFile := ".wget-mirror-" + host.Host + ".json"
+
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("first mirror failed: %v", err)
+	}
+	// Every page is index.html, so two of them get a number added to the name
+	m := &mirrorer{root: filepath.Join(dir, "site")}
+	first := m.readState(stateFile)
+
+	// The changed page now asks first, for the name the unchanged one had
+	site.set("/y/", `<html><body>Y changed</body></html>`)
+	slow.Store("/x/")
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("second mirror failed: %v", err)
+	}
+	second := m.readState(stateFile)
+	for _, page := range []string{"/x/", "/y/"} {
+		key := normalizeURL(server.URL + page)
+		if first[key] == nil || second[key] == nil || second[key].Path != first[key].Path {
+			t.Fatalf("%s should keep its name between runs: %+v then %+v", page, first[key], second[key])
+		}
+		body, _ := os.ReadFile(m.fullPath(second[key].Path))
+		site.mu.Lock()
+		want := site.pages[page]
+		site.mu.Unlock()
+		if string(body) != want {
+			t.Errorf("%s holds %q; want %q", second[key].Path, body, want)
+		}
+	}
+}
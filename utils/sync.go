@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultSyncMaxDelete is the share of a mirror --delete-after-sync removes at most
+// when --sync-max-delete is not given
+const defaultSyncMaxDelete = "50%"
+
+// syncLimit is the most files a sync may delete, as a count or as a percentage of
+// the files in the mirror
+type syncLimit struct {
+	count   int
+	percent float64
+	isShare bool
+}
+
+// parseSyncLimit reads a --sync-max-delete value such as 200 or 10%. An empty value
+// is the default limit.
+func parseSyncLimit(value string) (syncLimit, error) {
+	if value == "" {
+		value = defaultSyncMaxDelete
+	}
+	if number, ok := strings.CutSuffix(value, "%"); ok {
+		percent, err := strconv.ParseFloat(number, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return syncLimit{}, fmt.Errorf("invalid --sync-max-delete %q, expected a percentage between 0%% and 100%%", value)
+		}
+		return syncLimit{percent: percent, isShare: true}, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return syncLimit{}, fmt.Errorf("invalid --sync-max-delete %q, expected a number of files or a percentage", value)
+	}
+	return syncLimit{count: count}, nil
+}
+
+// allows reports whether deleting stale files out of total stays within the limit
+func (l syncLimit) allows(stale, total int) bool {
+	if l.isShare {
+		return total == 0 || float64(stale)*100 <= l.percent*float64(total)
+	}
+	return stale <= l.count
+}
+
+// checkSyncOptions validates the --delete-after-sync settings before a crawl starts
+func checkSyncOptions(opts MirrorOptions) error {
+	if !opts.DeleteAfterSync {
+		return nil
+	}
+	if _, err := parseSyncLimit(opts.SyncMaxDelete); err != nil {
+		return err
+	}
+	if (opts.NoDirectories || opts.NoHostDirectories) && opts.DirectoryPrefix == "" {
+		// Without host folders the whole download directory is scanned
+		return fmt.Errorf("--delete-after-sync with -nH or -nd requires -P, so files outside the mirror are never touched")
+	}
+	// Every file the crawl does not reach is deleted, so it must be able to reach
+	// every file the site still has
+	if !opts.Recursive || opts.Level != 0 {
+		return fmt.Errorf("--delete-after-sync requires a crawl of the whole site, --mirror or -r -l inf, and cannot be used with -p alone or a finite -l")
+	}
+	if len(opts.Accept) > 0 || len(opts.Reject) > 0 || opts.AcceptRegex != "" || opts.RejectRegex != "" || len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		return fmt.Errorf("cannot use --delete-after-sync with -A, -R, -I, -X, --accept-regex or --reject-regex, the files they skip would be deleted")
+	}
+	return nil
+}
+
+// isMirrorFile reports whether name is one of the files the mirror keeps for itself,
+// such as its state files and journal, which a sync never deletes
+func isMirrorFile(name string) bool {
+	return strings.HasPrefix(name, ".wget-") || name == "wget-log"
+}
+
+// syncScope returns the folders a sync looks for stale files in, relative to the
+// mirror root: the folder of every host the crawl downloaded from, or the whole
+// root when there are no host folders
+func (m *mirrorer) syncScope() []string {
+	if m.opts.NoDirectories || m.opts.NoHostDirectories {
+		return []string{"."}
+	}
+
+	dirs := make(map[string]bool)
+	m.mu.Lock()
+	for key := range m.files {
+		u, err := url.Parse(key)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		hostRoot := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}
+		dirs[path.Dir(m.localPath(hostRoot, otherContent))] = true
+	}
+	m.mu.Unlock()
+
+	scope := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		scope = append(scope, dir)
+	}
+	sort.Strings(scope)
+	return scope
+}
+
+// producedFiles returns the paths, relative to the mirror root, of every file this
+// crawl saved or kept from an earlier run
+func (m *mirrorer) producedFiles() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	produced := make(map[string]bool)
+	for key, entry := range m.files {
+		if entry.err == nil && entry.path != "" && !m.rejected[key] {
+			produced[entry.path] = true
+		}
+	}
+	return produced
+}
+
+// incompleteCrawl returns a download of this crawl that failed for a reason that may
+// not last, a network error or a server error, if any. The earlier copy of the file
+// could still be good, so nothing is deleted. A 4xx answer means the file is gone,
+// and a file over --max-filesize is not part of the mirror.
+func (m *mirrorer) incompleteCrawl() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.files {
+		var status *statusError
+		if entry.err == nil {
+			continue
+		}
+		if errors.As(entry.err, &status) && status.code < 500 {
+			continue
+		}
+		if errors.Is(entry.err, errFileTooLarge) {
+			continue
+		}
+		return entry.err
+	}
+	return nil
+}
+
+// syncFiles is --delete-after-sync: once the crawl is over, it lists the files in the
+// mirror the crawl did not produce and deletes them, or with --sync-dry-run only
+// lists them. It refuses when more files would go than --sync-max-delete allows.
+func (m *mirrorer) syncFiles() error {
+	limit, err := parseSyncLimit(m.opts.SyncMaxDelete)
+	if err != nil {
+		return err
+	}
+	if stopped := m.budget.summary(); stopped != "" {
+		fmt.Printf("\nNot deleting stale files, the crawl stopped early: %s\n", stopped)
+		return nil
+	}
+	if err := m.incompleteCrawl(); err != nil {
+		fmt.Printf("\nNot deleting stale files, the crawl was incomplete: %v\n", err)
+		return nil
+	}
+	scope := m.syncScope()
+
+	produced := m.producedFiles()
+	var stale []string
+	total := 0
+	for _, dir := range scope {
+		err := filepath.WalkDir(m.fullPath(dir), func(fullPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() || isMirrorFile(d.Name()) {
+				return nil
+			}
+			rel, err := filepath.Rel(m.fullPath("."), fullPath)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			total++
+			if !produced[rel] {
+				stale = append(stale, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error listing mirror files: %v", err)
+		}
+	}
+
+	fmt.Printf("\n%d of %d files in the mirror were not produced by this crawl\n", len(stale), total)
+	for _, rel := range stale {
+		fmt.Printf("Stale: %s\n", m.fullPath(rel))
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if !limit.allows(len(stale), total) {
+		if m.opts.SyncDryRun {
+			fmt.Printf("A real sync would refuse to delete them, --sync-max-delete is %s\n", syncMaxDelete(m.opts.SyncMaxDelete))
+			return nil
+		}
+		return fmt.Errorf("refusing to delete %d of %d files, more than --sync-max-delete=%s allows", len(stale), total, syncMaxDelete(m.opts.SyncMaxDelete))
+	}
+	if m.opts.SyncDryRun {
+		fmt.Printf("Dry run, nothing deleted\n")
+		return nil
+	}
+
+	deleted := 0
+	for _, rel := range stale {
+		if err := os.Remove(m.fullPath(rel)); err != nil {
+			fmt.Printf("Error deleting %s: %v\n", m.fullPath(rel), err)
+			continue
+		}
+		deleted++
+		m.mu.Lock()
+		m.deleted[rel] = true
+		m.mu.Unlock()
+		m.removeEmptyDirs(path.Dir(rel), scope)
+	}
+	fmt.Printf("Deleted %d stale files\n", deleted)
+	return nil
+}
+
+// syncMaxDelete returns the --sync-max-delete value in effect
+func syncMaxDelete(value string) string {
+	if value == "" {
+		return defaultSyncMaxDelete
+	}
+	return value
+}
+
+// removeEmptyDirs removes dir and its parents as long as they are empty, stopping at
+// the folders of scope
+func (m *mirrorer) removeEmptyDirs(dir string, scope []string) {
+	for dir != "." && dir != "/" {
+		for _, top := range scope {
+			if dir == top {
+				return
+			}
+		}
+		if os.Remove(m.fullPath(dir)) != nil {
+			return // not empty
+		}
+		dir = path.Dir(dir)
+	}
+}
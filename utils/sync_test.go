@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestParseSyncLimit(t *testing.T) {
+	tests := []struct {
+		value  string
+		stale  int
+		total  int
+		allows bool
+	}{
+		{"", 5, 10, true},
+		{"", 6, 10, false},
+		{"10%", 1, 10, true},
+		{"10%", 2, 10, false},
+		{"3", 3, 4, true},
+		{"3", 4, 100, false},
+		{"0", 1, 100, false},
+	}
+	for _, tt := range tests {
+		limit, err := parseSyncLimit(tt.value)
+		if err != nil {
+			t.Fatalf("parseSyncLimit(%q) failed: %v", tt.value, err)
+		}
+		if got := limit.allows(tt.stale, tt.total); got != tt.allows {
+			t.Errorf("limit %q allows(%d, %d) = %v; want %v", tt.value, tt.stale, tt.total, got, tt.allows)
+		}
+	}
+	for _, value := range []string{"-1", "150%", "ten", "%"} {
+		if _, err := parseSyncLimit(value); err == nil {
+			t.Errorf("parseSyncLimit(%q) should fail", value)
+		}
+	}
+}
+
+func TestCheckSyncOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts MirrorOptions
+		ok   bool
+	}{
+		{"mirror", MirrorOptions{Recursive: true}, true},
+		{"-nH without -P", MirrorOptions{Recursive: true, NoHostDirectories: true}, false},
+		{"-nH with -P", MirrorOptions{Recursive: true, NoHostDirectories: true, DirectoryPrefix: "site"}, true},
+		{"mirror with -p", MirrorOptions{Recursive: true, PageRequisites: true}, true},
+		{"-p alone", MirrorOptions{PageRequisites: true}, false},
+		{"finite -l", MirrorOptions{Recursive: true, Level: 1}, false},
+		{"-A", MirrorOptions{Recursive: true, Accept: []string{"html"}}, false},
+		{"-R", MirrorOptions{Recursive: true, Reject: []string{"jpg"}}, false},
+		{"-I", MirrorOptions{Recursive: true, Include: []string{"/docs"}}, false},
+		{"-X", MirrorOptions{Recursive: true, Exclude: []string{"/tmp"}}, false},
+		{"--reject-regex", MirrorOptions{Recursive: true, RejectRegex: `\?sort=`}, false},
+	}
+	for _, tt := range tests {
+		tt.opts.DeleteAfterSync = true
+		err := checkSyncOptions(tt.opts)
+		if tt.ok && err != nil {
+			t.Errorf("%s should be accepted: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s should be refused, files the crawl cannot reach would be deleted", tt.name)
+		}
+	}
+}
+
+// mutableSite serves pages that a test can change between mirrors
+type mutableSite struct {
+	mu     sync.Mutex
+	pages  map[string]string
+	broken map[string]bool // paths answered with 500
+}
+
+func (s *mutableSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken[r.URL.Path] {
+		http.Error(w, "down", http.StatusInternalServerError)
+		return
+	}
+	body, ok := s.pages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(body))
+}
+
+func TestMirrorWebsiteDeleteAfterSync(t *testing.T) {
+	site := &mutableSite{
+		pages: map[string]string{
+			"/":              `<html><body><a href="/a.html">A</a> <a href="/old/page.html">Old</a></body></html>`,
+			"/a.html":        `<html><body>A</body></html>`,
+			"/old/page.html": `<html><body>Old</body></html>`,
+		},
+		broken: make(map[string]bool),
+	}
+	server := httptest.NewServer(site)
+	defer server.Close()
+	dir := chdirTemp(t)
+	host, _ := url.Parse(server.URL)
+	hostDir := filepath.Join(dir, host.Host)
+	outside := filepath.Join(dir, "notes.txt")
+	os.WriteFile(outside, []byte("not part of the mirror"), 0644)
+
+	opts := MirrorOptions{Recursive: true}
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("first mirror failed: %v", err)
+	}
+	oldPage := filepath.Join(hostDir, "old", "page.html")
+	if _, err := os.Stat(oldPage); err != nil {
+		t.Fatalf("old page was not mirrored: %v", err)
+	}
+
+	// The old page is removed from the site
+	site.mu.Lock()
+	site.pages["/"] = `<html><body><a href="/a.html">A</a></body></html>`
+	delete(site.pages, "/old/page.html")
+	site.mu.Unlock()
+
+	opts.DeleteAfterSync = true
+	opts.SyncMaxDelete = "0"
+	if err := MirrorWebsite(server.URL+"/", opts); err == nil {
+		t.Errorf("a sync deleting more than --sync-max-delete should fail")
+	}
+	opts.SyncMaxDelete = ""
+	opts.SyncDryRun = true
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if _, err := os.Stat(oldPage); err != nil {
+		t.Errorf("a dry run or a refused sync should not delete anything: %v", err)
+	}
+
+	// A server error leaves the crawl incomplete, so nothing is deleted
+	opts.SyncDryRun = false
+	site.mu.Lock()
+	site.broken["/a.html"] = true
+	site.mu.Unlock()
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("mirror with a broken page failed: %v", err)
+	}
+	if _, err := os.Stat(oldPage); err != nil {
+		t.Errorf("an incomplete crawl should not delete anything: %v", err)
+	}
+
+	site.mu.Lock()
+	delete(site.broken, "/a.html")
+	site.mu.Unlock()
+	if err := MirrorWebsite(server.URL+"/", opts); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if _, err := os.Stat(oldPage); !os.IsNotExist(err) {
+		t.Errorf("stale page should be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(hostDir, "old")); !os.IsNotExist(err) {
+		t.Errorf("folder left empty should be removed")
+	}
+	for _, keep := range []string{filepath.Join(hostDir, "index.html"), filepath.Join(hostDir, "a.html"), filepath.Join(hostDir, mirrorStateName), outside} {
+		if _, err := os.Stat(keep); err != nil {
+			t.Errorf("%s should be kept: %v", keep, err)
+		}
+	}
+}